@@ -2,23 +2,36 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"math"
 	"slices"
+	"strings"
 	"time"
+	"unicode/utf16"
 
 	"github.com/getlantern/systray"
 	"github.com/k0kubun/pp/v3"
-	"github.com/labstack/gommon/log"
 	"github.com/skratchdot/open-golang/open"
 
+	"github.com/rszyma/kanata-tray/app/control_api"
+	"github.com/rszyma/kanata-tray/app/notifications"
+	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/logging"
 	runner_pkg "github.com/rszyma/kanata-tray/runner"
+	"github.com/rszyma/kanata-tray/runner/tcp_client"
 	"github.com/rszyma/kanata-tray/status_icons"
 )
 
 type SystrayApp struct {
 	logFilepath string
 
+	kanataLogsDir     string
+	kanataLogMaxSize  int64
+	kanataLogMaxFiles int
+
+	runner *runner_pkg.Runner
+
 	concurrentPresets bool
 
 	// Used when `concurrentPresets` is disabled.
@@ -29,21 +42,60 @@ type SystrayApp struct {
 	statuses                 []KanataStatus
 	presetCancelFuncs        []context.CancelFunc // cancel functions can be nil
 	presetAutorestartLimiter []RestartLimiter
-	presetLogFiles           []*os.File
-
-	currentIconData []byte
-	layerIcons      LayerIcons
+	presetLogFiles           []*kanataLog // nil if preset hasn't been run yet
+	// Set when a running preset has to be started again once it exits
+	// (e.g. because its settings changed after config reload).
+	presetRestartOnExit   []bool
+	presetCurrentLayers   []string          // empty if unknown
+	presetLastErrors      []error           // error from the last exit of a preset
+	presetRestartCounts   []int             // number of autorestarts since kanata-tray started
+	presetLayerNames      [][]string        // as reported by kanata, nil if unknown
+	presetPendingRestarts []*pendingRestart // nil if no autorestart is pending
+	presetLastActivity    []time.Time       // last start, crash or layer change
+
+	layerIcons LayerIcons
+
+	iconPolicy        config.IconPolicy
+	iconAnimator      *iconAnimator
+	animationOpts     IconAnimationOptions
+	startingAnimation iconAnimation
+	crashAnimation    iconAnimation
+	// Identifies the currently shown icon, see `refreshIcon`.
+	shownIconKey string
+
+	tooltipTemplate   string
+	menuTitleTemplate string
+	shownTooltip      string
+
+	events *eventBroadcaster
+	// Senders of client messages awaiting kanata response, by preset name,
+	// see `SendClientMessage`.
+	clientMessageWaiters map[string][]chan tcp_client.ServerMessage
+
+	notifier   *notifications.Notifier
+	notifyOpts NotifyOptions
 
 	togglePresetCh   chan int // the value sent in channel is an index of preset
-	startPresetCh    chan int // the value sent in channel is an index of preset
-	stopPresetChan   chan int // the value sent in channel is an index of preset
 	openPresetLogsCh chan int // the value sent in channel is an index of preset
+	openHookLogsCh   chan int // the value sent in channel is an index of preset
+	reloadConfigCh   chan Opts
+	statusRequestCh  chan chan control_api.TrayStatus
+	apiRequestCh     chan func() // see `runInLoop`
+	changeLayerCh    chan layerMenuClick
 
 	// Menu items
 
+	// Menu items for presets are reused between config reloads, because
+	// systray doesn't support removing menu items. There may be more of them
+	// than presets; the ones without a preset are hidden.
 	mPresets        []*systray.MenuItem
 	mPresetLogs     []*systray.MenuItem
+	mPresetHookLogs []*systray.MenuItem
 	mPresetStatuses []*systray.MenuItem
+	// "Layers" submenu for each preset, and items in it. Items are reused
+	// when layer names change and the unused ones are hidden.
+	mPresetLayersMenus []*systray.MenuItem
+	mPresetLayers      [][]*systray.MenuItem
 
 	mOptions  *systray.MenuItem
 	mShowLogs *systray.MenuItem
@@ -55,15 +107,38 @@ type Opts struct {
 	LayerIcons             LayerIcons
 	AllowConcurrentPresets bool
 	LogFilepath            string
+	// Directory in which per-preset kanata logs are stored.
+	KanataLogsDir     string
+	KanataLogMaxSize  int64
+	KanataLogMaxFiles int
+	Notify            NotifyOptions
+	IconAnimations    IconAnimationOptions
+	IconPolicy        config.IconPolicy
+	// Templates of tray tooltip and preset menu titles.
+	Tooltip   string
+	MenuTitle string
+	Runner    *runner_pkg.Runner
 }
 
 func NewSystrayApp(opts Opts) *SystrayApp {
+	logPresetDiagnostics(opts.MenuTemplate)
 	return &SystrayApp{
 		logFilepath:          opts.LogFilepath,
+		kanataLogsDir:        opts.KanataLogsDir,
+		kanataLogMaxSize:     opts.KanataLogMaxSize,
+		kanataLogMaxFiles:    opts.KanataLogMaxFiles,
+		runner:               opts.Runner,
 		presets:              opts.MenuTemplate,
 		scheduledPresetIndex: -1,
 		layerIcons:           opts.LayerIcons,
 		concurrentPresets:    opts.AllowConcurrentPresets,
+		events:               newEventBroadcaster(),
+		notifier:             notifications.NewNotifier(),
+		notifyOpts:           opts.Notify,
+		animationOpts:        opts.IconAnimations,
+		iconPolicy:           opts.IconPolicy,
+		tooltipTemplate:      opts.Tooltip,
+		menuTitleTemplate:    opts.MenuTitle,
 	}
 }
 
@@ -72,73 +147,155 @@ func (a *SystrayApp) InitSystray() *SystrayApp {
 		panic("InitSystray must be called on a freshly created instance")
 	}
 
-	systray.SetIcon(status_icons.Default)
-	systray.SetTooltip("kanata-tray")
-
-	for _, entry := range a.presets {
-		menuItem := systray.AddMenuItem(entry.Title(statusIdle), entry.Tooltip())
-		if !entry.IsSelectable {
-			menuItem.Disable()
-		}
-		a.mPresets = append(a.mPresets, menuItem)
-
-		statusItem := menuItem.AddSubMenuItem(string(statusIdle), "kanata status for this preset")
-		a.mPresetStatuses = append(a.mPresetStatuses, statusItem)
+	a.iconAnimator = newIconAnimator(systray.SetIcon)
+	a.startingAnimation = startingAnimation()
+	a.crashAnimation = blinkingIcon(status_icons.Crash, 500*time.Millisecond, 10)
+	a.setIcon(status_icons.Default)
+
+	a.togglePresetCh = make(chan int)
+	a.openPresetLogsCh = make(chan int)
+	a.openHookLogsCh = make(chan int)
+	a.reloadConfigCh = make(chan Opts)
+	a.statusRequestCh = make(chan chan control_api.TrayStatus)
+	a.apiRequestCh = make(chan func())
+	a.clientMessageWaiters = make(map[string][]chan tcp_client.ServerMessage)
+	a.changeLayerCh = make(chan layerMenuClick)
+
+	for range a.presets {
 		a.statuses = append(a.statuses, statusIdle)
-
 		a.presetCancelFuncs = append(a.presetCancelFuncs, nil)
-
-		openLogsItem := menuItem.AddSubMenuItem("Open kanata logs", "Open kanata log file")
-		a.mPresetLogs = append(a.mPresetLogs, openLogsItem)
-
 		a.presetAutorestartLimiter = append(a.presetAutorestartLimiter, RestartLimiter{})
-
 		a.presetLogFiles = append(a.presetLogFiles, nil)
+		a.presetRestartOnExit = append(a.presetRestartOnExit, false)
+		a.presetCurrentLayers = append(a.presetCurrentLayers, "")
+		a.presetLastErrors = append(a.presetLastErrors, nil)
+		a.presetRestartCounts = append(a.presetRestartCounts, 0)
+		a.presetLayerNames = append(a.presetLayerNames, nil)
+		a.presetPendingRestarts = append(a.presetPendingRestarts, nil)
+		a.presetLastActivity = append(a.presetLastActivity, time.Time{})
+		a.addPresetMenuSlot()
 	}
+	a.refreshPresetMenuSlots()
+	a.refreshTooltip()
 
 	systray.AddSeparator()
+	a.addFooterMenuItems()
 
+	return a
+}
+
+// Adds menu items for one more preset at the bottom of the menu.
+// The caller is responsible for setting titles with `refreshPresetMenuSlots`.
+func (a *SystrayApp) addPresetMenuSlot() {
+	i := len(a.mPresets)
+
+	menuItem := systray.AddMenuItem("", "")
+	a.mPresets = append(a.mPresets, menuItem)
+
+	statusItem := menuItem.AddSubMenuItem(string(statusIdle), "kanata status for this preset")
+	a.mPresetStatuses = append(a.mPresetStatuses, statusItem)
+	listenForClicks(statusItem, i, a.togglePresetCh)
+
+	layersMenu := menuItem.AddSubMenuItem("Layers", "Switch active kanata layer")
+	layersMenu.Hide()
+	a.mPresetLayersMenus = append(a.mPresetLayersMenus, layersMenu)
+	a.mPresetLayers = append(a.mPresetLayers, nil)
+
+	openLogsItem := menuItem.AddSubMenuItem("Open kanata logs", "Open kanata log file")
+	a.mPresetLogs = append(a.mPresetLogs, openLogsItem)
+	listenForClicks(openLogsItem, i, a.openPresetLogsCh)
+
+	openHookLogsItem := menuItem.AddSubMenuItem("Open hook logs", "Open recent output of hooks")
+	a.mPresetHookLogs = append(a.mPresetHookLogs, openHookLogsItem)
+	listenForClicks(openHookLogsItem, i, a.openHookLogsCh)
+}
+
+func (a *SystrayApp) addFooterMenuItems() {
 	a.mOptions = systray.AddMenuItem("Configure", "Reveals kanata-tray config file")
 	a.mShowLogs = systray.AddMenuItem("Open logs", "Reveals kanata-tray log file")
 	a.mQuit = systray.AddMenuItem("Exit tray", "Closes kanata (if running) and exits the tray")
+}
 
-	a.togglePresetCh = multipleMenuItemsClickListener(a.mPresetStatuses)
-	a.startPresetCh = make(chan int)
-	a.stopPresetChan = make(chan int)
-	a.openPresetLogsCh = multipleMenuItemsClickListener(a.mPresetLogs)
-
-	return a
+// Updates all preset menu items to reflect current presets and their statuses.
+// Menu slots that don't have a corresponding preset are hidden.
+func (a *SystrayApp) refreshPresetMenuSlots() {
+	for i, menuItem := range a.mPresets {
+		if i >= len(a.presets) {
+			menuItem.Hide()
+			continue
+		}
+		entry := a.presets[i]
+		menuItem.SetTooltip(entry.Tooltip())
+		if entry.IsSelectable {
+			menuItem.Enable()
+		} else {
+			menuItem.Disable()
+		}
+		a.setStatus(i, a.statuses[i])
+		menuItem.Show()
+	}
 }
 
-func (a *SystrayApp) runPreset(presetIndex int, runner *runner_pkg.Runner) {
+func (a *SystrayApp) runPreset(presetIndex int) {
+	if err := a.presets[presetIndex].validationErr(); err != nil {
+		logging.Errorf(a.presetLogFields(presetIndex), "Not running preset '%s': %v", a.presets[presetIndex].PresetName, err)
+		a.presetLastErrors[presetIndex] = err
+		if a.presetPendingRestarts[presetIndex] != nil {
+			// e.g. the preset became invalid after config reload
+			a.presetPendingRestarts[presetIndex] = nil
+			a.giveUpAutorestart(presetIndex, "Preset can't be run with the current config.")
+		}
+		return
+	}
+	if !a.concurrentPresets {
+		// Only one preset can run at a time, so a pending autorestart
+		// of another preset would stop this one.
+		for i := range a.presets {
+			if i != presetIndex {
+				a.cancelPendingRestart(i)
+			}
+		}
+	}
 	if !a.concurrentPresets && a.isAnyPresetRunning() {
-		log.Infof("Switching preset to '%s'", a.presets[presetIndex].PresetName)
+		logging.Infof(a.presetLogFields(presetIndex), "Switching preset to '%s'", a.presets[presetIndex].PresetName)
 		for i := range a.presets {
 			a.cancel(i)
+			a.cancelPendingRestart(i)
 			a.setStatus(i, statusIdle)
 		}
 		if a.scheduledPresetIndex != -1 {
-			log.Warnf("the previously scheduled preset was not ran!")
+			logging.Warnf(a.presetLogFields(a.scheduledPresetIndex), "the previously scheduled preset was not ran!")
 		}
 		a.scheduledPresetIndex = presetIndex
 		// Preset has been scheduled to run, and will actutally be run when the previous one exits.
 		return
 	}
 
-	log.Infof("Running preset '%s'", a.presets[presetIndex].PresetName)
+	logFields := a.presetLogFields(presetIndex)
+	logging.Infof(logFields.With("event", "preset_starting"), "Running preset '%s'", a.presets[presetIndex].PresetName)
+	a.presetPendingRestarts[presetIndex] = nil
+	a.touchPreset(presetIndex)
 	a.setStatus(presetIndex, statusStarting)
 
-	a.presetLogFiles[presetIndex].Close()
+	if f := a.presetLogFiles[presetIndex]; f != nil {
+		f.Close()
+	}
 	var err error
-	a.presetLogFiles[presetIndex], err = os.CreateTemp("", "kanata_lastrun_*.log")
+	a.presetLogFiles[presetIndex], err = openKanataLog(
+		a.kanataLogsDir,
+		a.presets[presetIndex].PresetName,
+		a.kanataLogMaxSize,
+		a.kanataLogMaxFiles,
+	)
 	if err != nil {
-		log.Errorf("failed to create temp log file: %v", err)
+		logging.Errorf(logFields, "failed to create kanata log file: %v", err)
+		a.presetLastErrors[presetIndex] = err
 		a.setStatus(presetIndex, statusCrashed)
 		return
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	err = runner.Run(
+	err = a.runner.Run(
 		ctx,
 		a.presets[presetIndex].PresetName,
 		a.presets[presetIndex].Preset.KanataExecutable,
@@ -147,139 +304,244 @@ func (a *SystrayApp) runPreset(presetIndex int, runner *runner_pkg.Runner) {
 		a.presets[presetIndex].Preset.Hooks,
 		a.presets[presetIndex].Preset.ExtraArgs,
 		a.presetLogFiles[presetIndex],
+		a.presets[presetIndex].Preset.StartupTimeout,
 	)
 	if err != nil {
-		log.Errorf("runner.Run failed with: %v", err)
+		logging.Errorf(logFields.With("event", "preset_crashed"), "runner.Run failed with: %v", err)
+		a.presetLastErrors[presetIndex] = err
 		a.setStatus(presetIndex, statusCrashed)
 		cancel()
 		return
 	}
 	a.cancel(presetIndex)
-	a.setStatus(presetIndex, statusRunning)
+	// Status is changed to running once kanata reports readiness.
 	a.presetCancelFuncs[presetIndex] = cancel
 }
 
-func (a *SystrayApp) StartProcessingLoop(runner *runner_pkg.Runner, configFolder string) {
-	a.setIcon(status_icons.Pause)
+func (a *SystrayApp) StartProcessingLoop(configFolder string) {
+	a.refreshIcon(false)
+
+	serverMessageCh := a.runner.ServerMessageCh()
+	retCh := a.runner.RetCh()
+	readyCh := a.runner.ReadyCh()
 
-	serverMessageCh := runner.ServerMessageCh()
-	retCh := runner.RetCh()
+	// Drives delayed autorestarts and their countdowns in the menu.
+	restartTicker := time.NewTicker(time.Second)
+	defer restartTicker.Stop()
 
 	for {
 		select {
 		case event := <-serverMessageCh:
-			log.Debugf("Received an event from kanata (preset=%s): %v, ", event.PresetName, pp.Sprint(event.Item))
+			logging.Debugf(logging.Fields{"preset": event.PresetName}, "Received an event from kanata: %v", pp.Sprint(event.Item))
 
 			// fmt.Printf("Received an event from kanata: %v\n", pp.Sprint(event))
 			if event.Item.LayerChange != nil {
-				icon := a.layerIcons.IconForLayerName(event.PresetName, event.Item.LayerChange.NewLayer)
-				if icon == nil {
-					icon = status_icons.Default
+				logging.Debugf(logging.Fields{"preset": event.PresetName, "event": "layer_change", "layer": event.Item.LayerChange.NewLayer}, "Kanata switched layer")
+				if i, err := a.indexFromPresetName(event.PresetName); err == nil {
+					a.setCurrentLayer(i, event.Item.LayerChange.NewLayer)
+					a.touchPreset(i)
+					a.refreshIcon(true)
 				}
-				a.setIcon(icon)
+				a.events.publish(control_api.Event{
+					Type:   control_api.EventLayerChange,
+					Preset: event.PresetName,
+					Time:   time.Now(),
+					Layer:  event.Item.LayerChange.NewLayer,
+				})
+				a.notifyLayerChange(event.PresetName, event.Item.LayerChange.NewLayer)
 			}
 			if event.Item.LayerNames != nil {
+				if i, err := a.indexFromPresetName(event.PresetName); err == nil {
+					a.presetLayerNames[i] = event.Item.LayerNames.Names
+					a.refreshLayersMenu(i)
+				}
+				a.events.publish(control_api.Event{
+					Type:   control_api.EventLayerNames,
+					Preset: event.PresetName,
+					Time:   time.Now(),
+					Layers: event.Item.LayerNames.Names,
+				})
 				mappedLayers := a.layerIcons.MappedLayers(event.PresetName)
 				for _, mappedLayerName := range mappedLayers {
 					found := slices.Contains(event.Item.LayerNames.Names, mappedLayerName)
 					if !found {
-						log.Warnf("Layer '%s' is mapped to an icon, but doesn't exist in the loaded kanata config", mappedLayerName)
+						logging.Warnf(logging.Fields{"preset": event.PresetName, "layer": mappedLayerName}, "Layer '%s' is mapped to an icon, but doesn't exist in the loaded kanata config", mappedLayerName)
 					}
 				}
 			}
+			if event.Item.CurrentLayerName != nil || event.Item.CurrentLayerInfo != nil {
+				layerName := ""
+				if event.Item.CurrentLayerName != nil {
+					layerName = event.Item.CurrentLayerName.Name
+				} else {
+					layerName = event.Item.CurrentLayerInfo.Name
+				}
+				if i, err := a.indexFromPresetName(event.PresetName); err == nil {
+					a.setCurrentLayer(i, layerName)
+					a.refreshIcon(false)
+				}
+			}
+			if event.Item.Error != nil {
+				logging.Errorf(logging.Fields{"preset": event.PresetName, "event": "kanata_error"}, "Kanata reported an error: %s", event.Item.Error.Msg)
+			}
+			if event.Item.ResponseStatus == "Error" {
+				logging.Errorf(logging.Fields{"preset": event.PresetName, "event": "kanata_error"}, "Kanata rejected a message: %s", event.Item.ResponseMsg)
+			}
+			if event.Item.ResponseStatus != "" {
+				a.deliverClientMessageResponse(event.PresetName, event.Item)
+			}
 			if event.Item.ConfigFileReload != nil {
-				prevIcon := a.currentIconData
-				a.setIcon(status_icons.LiveReload)
-				time.Sleep(150 * time.Millisecond)
-				a.setIcon(prevIcon)
+				a.events.publish(control_api.Event{
+					Type:   control_api.EventConfigFileReload,
+					Preset: event.PresetName,
+					Time:   time.Now(),
+				})
+				a.iconAnimator.Flash(iconAnimation{
+					frames:        [][]byte{status_icons.LiveReload},
+					frameDuration: 150 * time.Millisecond,
+				})
 			}
+		case ready := <-readyCh:
+			i, err := a.indexFromPresetName(ready.PresetName)
+			if err != nil || a.statuses[i] != statusStarting {
+				continue
+			}
+			logging.Infof(a.presetLogFields(i).With("event", "preset_running"), "Preset '%s' is ready", ready.PresetName)
+			a.setStatus(i, statusRunning)
 		case ret := <-retCh:
 			runnerPipelineErr := ret.Item
+			a.dropClientMessageWaiters(ret.PresetName)
 			i, err := a.indexFromPresetName(ret.PresetName)
 			if err != nil {
-				log.Errorf("Preset not found: %s", ret.PresetName)
+				// The preset was removed from config while it was running.
+				logging.Infof(logging.Fields{"preset": ret.PresetName}, "Preset '%s' (no longer in config) exited", ret.PresetName)
+				if a.scheduledPresetIndex != -1 {
+					a.runPreset(a.scheduledPresetIndex)
+					a.scheduledPresetIndex = -1
+				}
 				continue
 			}
+			logFields := a.presetLogFields(i)
+			// Cancel func is cleared when preset is stopped from kanata-tray.
+			exitedByItself := a.presetCancelFuncs[i] != nil
 			a.cancel(i)
+			a.presetCurrentLayers[i] = ""
+			a.presetLayerNames[i] = nil
+			a.presetLastErrors[i] = runnerPipelineErr
 			if runnerPipelineErr != nil {
-				log.Errorf("Kanata runner terminated with an error: %v", runnerPipelineErr)
+				logging.Errorf(logFields.With("event", "preset_crashed"), "Kanata runner terminated with an error: %v", runnerPipelineErr)
+				// Kanata wasn't started if a pre-start hook failed, so there's no crash log to keep.
+				var hookErr *runner_pkg.HookError
+				isPreStartHookErr := errors.As(runnerPipelineErr, &hookErr) && hookErr.HookType == "pre-start"
+				if f := a.presetLogFiles[i]; f != nil && !isPreStartHookErr {
+					err := f.KeepAsCrashLog()
+					if err != nil {
+						logging.Errorf(logFields, "Failed to keep kanata log of the crashed run: %v", err)
+					} else {
+						logging.Infof(logFields, "Kanata log of the crashed run was saved to '%s'", f.Name())
+					}
+				}
+				a.touchPreset(i)
 				a.setStatus(i, statusCrashed)
-				a.setIcon(status_icons.Crash)
+				a.notifyPresetError(i, runnerPipelineErr)
 
 				if a.presets[i].Preset.AutorestartOnCrash {
-					attemptCount, isAllowed := a.presetAutorestartLimiter[i].BeginAttempt()
-					if isAllowed {
-						log.Infof("[autorestart-on-crash] Restarting [%d/%d]", attemptCount, AutorestartLimit)
-						a.runPreset(i, runner)
-					} else {
-						log.Warnf("[autorestart-on-crash] Restarts have been triggering too rapidly. Stopping futher attempts.")
-						a.presetAutorestartLimiter[i].Clear()
-					}
+					a.autorestart(i)
 				}
 			} else {
-				log.Infof("Previous kanata process terminated successfully")
+				logging.Infof(logFields.With("event", "preset_stopped"), "Previous kanata process terminated successfully")
 				a.setStatus(i, statusIdle)
-				if a.isAnyPresetRunning() {
-					a.setIcon(status_icons.Default)
-				} else {
-					a.setIcon(status_icons.Pause)
+				preset := a.presets[i].Preset
+				if exitedByItself && preset.AutorestartOnCrash && preset.AutorestartPolicy.RestartOnCleanExit {
+					logging.Infof(logFields, "[autorestart-on-crash] Kanata exited by itself")
+					a.autorestart(i)
 				}
 			}
+			if a.presetRestartOnExit[i] {
+				a.presetRestartOnExit[i] = false
+				logging.Infof(logFields, "Starting preset '%s' again with updated settings", ret.PresetName)
+				a.runPreset(i)
+			}
 			if a.scheduledPresetIndex != -1 {
-				a.runPreset(a.scheduledPresetIndex, runner)
+				a.runPreset(a.scheduledPresetIndex)
 				a.scheduledPresetIndex = -1
 			}
 		case i := <-a.togglePresetCh:
+			if i >= len(a.presets) {
+				continue
+			}
 			switch a.statuses[i] {
 			case statusIdle:
 				// run kanata
-				a.runPreset(i, runner)
-			case statusRunning:
+				a.runPreset(i)
+			case statusRunning, statusStarting:
 				// stop kanata
 				a.cancel(i)
 			case statusCrashed:
 				// restart kanata (from crashed state)
 				a.presetAutorestartLimiter[i].Clear()
-				a.runPreset(i, runner)
+				a.runPreset(i)
 			}
-		case i := <-a.stopPresetChan:
-			switch a.statuses[i] {
-			case statusIdle:
-				// already not running, do nothing
-			case statusRunning:
-				// stop kanata
-				a.cancel(i)
-			case statusCrashed:
-				// already not running, do nothing
+		case click := <-a.changeLayerCh:
+			layerName, ok := a.clickedLayer(click)
+			if !ok {
+				continue
 			}
-		case i := <-a.startPresetCh:
-			switch a.statuses[i] {
-			case statusIdle:
-				// run kanata
-				a.runPreset(i, runner)
-			case statusRunning:
-				// alredy running, do nothing
-			case statusCrashed:
-				// restart kanata (from crashed state)
-				a.presetAutorestartLimiter[i].Clear()
-				a.runPreset(i, runner)
+			presetName := a.presets[click.presetIndex].PresetName
+			logging.Infof(a.presetLogFields(click.presetIndex).With("layer", layerName), "Switching layer of preset '%s' to '%s'", presetName, layerName)
+			err := a.runner.SendClientMessage(presetName, tcp_client.ClientMessage{
+				ChangeLayer: &tcp_client.ChangeLayer{NewLayer: layerName},
+			})
+			if err != nil {
+				logging.Errorf(a.presetLogFields(click.presetIndex).With("layer", layerName), "Failed to switch layer: %v", err)
+			} else {
+				// Keeps responses to messages sent via control server in order.
+				a.clientMessageWaiters[presetName] = append(a.clientMessageWaiters[presetName], nil)
 			}
+		case <-restartTicker.C:
+			a.processPendingRestarts()
+		case respCh := <-a.statusRequestCh:
+			respCh <- a.trayStatus()
+		case fn := <-a.apiRequestCh:
+			fn()
+		case opts := <-a.reloadConfigCh:
+			a.applyConfig(opts)
 		case i := <-a.openPresetLogsCh:
+			if i >= len(a.presets) {
+				continue
+			}
 			presetName := a.presets[i].PresetName
 			f := a.presetLogFiles[i]
 			if f == nil {
-				log.Warnf("No log file found for preset '%s'", presetName)
+				logging.Warnf(a.presetLogFields(i), "No log file found for preset '%s'", presetName)
 			} else {
 				filename := f.Name()
-				log.Debugf("Opening log file for preset '%s': '%s'", presetName, filename)
+				logging.Debugf(a.presetLogFields(i), "Opening log file for preset '%s': '%s'", presetName, filename)
 				open.Start(filename)
 			}
+		case i := <-a.openHookLogsCh:
+			if i >= len(a.presets) {
+				continue
+			}
+			presetName := a.presets[i].PresetName
+			output, ok := a.runner.HookOutput(presetName)
+			if !ok {
+				logging.Warnf(a.presetLogFields(i), "No hooks have been run for preset '%s' yet", presetName)
+				continue
+			}
+			filename, err := writeHookLog(a.kanataLogsDir, presetName, output.String())
+			if err != nil {
+				logging.Errorf(a.presetLogFields(i), "Failed to write hook log file for preset '%s': %v", presetName, err)
+				continue
+			}
+			logging.Debugf(a.presetLogFields(i), "Opening hook log file for preset '%s': '%s'", presetName, filename)
+			open.Start(filename)
 		case <-a.mOptions.ClickedCh:
 			open.Start(configFolder)
 		case <-a.mShowLogs.ClickedCh:
 			open.Start(a.logFilepath)
 		case <-a.mQuit.ClickedCh:
-			log.Info("Clicked \"Exit tray button\", exiting.")
+			logging.Infof(nil, "Clicked \"Exit tray button\", exiting.")
 			a.Cleanup()
 			systray.Quit()
 			return
@@ -289,6 +551,11 @@ func (a *SystrayApp) StartProcessingLoop(runner *runner_pkg.Runner, configFolder
 
 // Run all presets with autorun=true. NOOP if they are running already.
 func (a *SystrayApp) Autorun() {
+	a.runInLoop(a.autorun)
+}
+
+// Must be called from the processing loop.
+func (a *SystrayApp) autorun() {
 	autoranOnePreset := false
 	for i, preset := range a.presets {
 		if preset.Preset.Autorun {
@@ -296,16 +563,45 @@ func (a *SystrayApp) Autorun() {
 				if !autoranOnePreset {
 					autoranOnePreset = true
 				} else {
-					log.Warnf("more than 1 preset has autorun enabled, but " +
+					logging.Warnf(nil, "more than 1 preset has autorun enabled, but "+
 						"can't run them all, because `allow_concurrent_presets` is not enabled.")
 					break
 				}
 			}
-			a.startPresetCh <- i
+			a.startPreset(i)
 		}
 	}
 }
 
+// Runs a preset, unless it's already running. Must be called from the processing loop.
+func (a *SystrayApp) startPreset(presetIndex int) {
+	switch a.statuses[presetIndex] {
+	case statusIdle:
+		// run kanata
+		a.runPreset(presetIndex)
+	case statusRunning, statusStarting:
+		// alredy running, do nothing
+	case statusCrashed:
+		// restart kanata (from crashed state)
+		a.presetAutorestartLimiter[presetIndex].Clear()
+		a.runPreset(presetIndex)
+	}
+}
+
+// Stops a preset and cancels its pending autorestart. Must be called from the processing loop.
+func (a *SystrayApp) stopPreset(presetIndex int) {
+	switch a.statuses[presetIndex] {
+	case statusIdle:
+		// already not running, do nothing
+	case statusRunning, statusStarting:
+		// stop kanata
+		a.cancel(presetIndex)
+	case statusCrashed:
+		// already not running, only cancel pending restart
+		a.cancelPendingRestart(presetIndex)
+	}
+}
+
 func (a *SystrayApp) Cleanup() {
 	deadline := time.Now().Add(6 * time.Second)
 	for time.Now().Before(deadline) {
@@ -324,7 +620,7 @@ func (a *SystrayApp) Cleanup() {
 			return
 		}
 	}
-	log.Warn("Cleanup deadline exceeded, releasing block")
+	logging.Warnf(nil, "Cleanup deadline exceeded, releasing block")
 }
 
 func (a *SystrayApp) indexFromPresetName(presetName string) (int, error) {
@@ -336,14 +632,268 @@ func (a *SystrayApp) indexFromPresetName(presetName string) (int, error) {
 	return 0, fmt.Errorf("preset with the specified name doesn't exist")
 }
 
+func (a *SystrayApp) presetLogFields(presetIndex int) logging.Fields {
+	return logging.Fields{"preset": a.presets[presetIndex].PresetName}
+}
+
 func (a *SystrayApp) isAnyPresetRunning() bool {
-	return slices.Contains(a.statuses, statusRunning)
+	return slices.Contains(a.statuses, statusRunning) || slices.Contains(a.statuses, statusStarting)
+}
+
+func (a *SystrayApp) trayStatus() control_api.TrayStatus {
+	result := control_api.TrayStatus{
+		AllowConcurrentPresets: a.concurrentPresets,
+		RunningPresets:         []string{},
+		Presets:                make([]control_api.PresetStatus, len(a.presets)),
+	}
+	for i, entry := range a.presets {
+		status := control_api.PresetStatus{
+			Name:         entry.PresetName,
+			Status:       a.statuses[i].Name(),
+			TcpPort:      entry.Preset.TcpPort,
+			CurrentLayer: a.presetCurrentLayers[i],
+			RestartCount: a.presetRestartCounts[i],
+		}
+		if pid, ok := a.runner.Pid(entry.PresetName); ok {
+			status.Pid = pid
+		}
+		if port, ok := a.runner.TcpPort(entry.PresetName); ok {
+			status.TcpPort = port
+		}
+		if err := a.presetLastErrors[i]; err != nil {
+			status.LastError = err.Error()
+		}
+		for _, d := range entry.Diagnostics {
+			status.Diagnostics = append(status.Diagnostics, d.String())
+		}
+		if f := a.presetLogFiles[i]; f != nil {
+			status.LogFile = f.Name()
+		}
+		switch a.statuses[i] {
+		case statusRunning, statusStarting:
+			result.RunningPresets = append(result.RunningPresets, entry.PresetName)
+		case statusIdle, statusCrashed:
+		}
+		result.Presets[i] = status
+	}
+	return result
 }
 
 func (a *SystrayApp) setStatus(presetIndex int, status KanataStatus) {
+	if a.statuses[presetIndex] != status {
+		event := control_api.Event{
+			Type:   control_api.EventStatus,
+			Preset: a.presets[presetIndex].PresetName,
+			Time:   time.Now(),
+			Status: status.Name(),
+		}
+		if err := a.presetLastErrors[presetIndex]; err != nil && status == statusCrashed {
+			event.Error = err.Error()
+		}
+		a.events.publish(event)
+	}
 	a.statuses[presetIndex] = status
-	a.mPresetStatuses[presetIndex].SetTitle(string(status))
-	a.mPresets[presetIndex].SetTitle(a.presets[presetIndex].Title(status))
+	a.mPresetStatuses[presetIndex].SetTitle(a.statusTitle(presetIndex))
+	a.refreshPresetTitle(presetIndex)
+	a.refreshLayersMenu(presetIndex)
+	a.refreshTooltip()
+	a.refreshIcon(false)
+}
+
+// Sets the active layer of a preset, as reported by kanata.
+func (a *SystrayApp) setCurrentLayer(presetIndex int, layerName string) {
+	a.presetCurrentLayers[presetIndex] = layerName
+	a.refreshPresetTitle(presetIndex)
+	a.refreshLayersMenu(presetIndex)
+	a.refreshTooltip()
+}
+
+func (a *SystrayApp) refreshPresetTitle(presetIndex int) {
+	title := a.presets[presetIndex].Title(a.menuTitleTemplate, a.statuses[presetIndex], a.presetCurrentLayers[presetIndex])
+	a.mPresets[presetIndex].SetTitle(title)
+}
+
+// Updates tray tooltip with a line for each preset that isn't idle,
+// formatted with `tooltipTemplate`.
+func (a *SystrayApp) refreshTooltip() {
+	var lines []string
+	for i, entry := range a.presets {
+		if a.statuses[i] == statusIdle || a.tooltipTemplate == "" {
+			continue
+		}
+		lines = append(lines, entry.expandTemplate(a.tooltipTemplate, a.statuses[i], a.presetCurrentLayers[i]))
+	}
+	tooltip := "kanata-tray"
+	if len(lines) > 0 {
+		tooltip = strings.Join(lines, "\n")
+	}
+	tooltip = truncateTooltip(tooltip)
+	if tooltip == a.shownTooltip {
+		return
+	}
+	a.shownTooltip = tooltip
+	systray.SetTooltip(tooltip)
+}
+
+// Tooltip is stored in a 128-element UTF-16 array (with NUL terminator) on Windows.
+const maxTooltipLen = 127
+
+// Cuts tooltip to at most `maxTooltipLen` UTF-16 code units, ending it with "…"
+// if it was cut. Surrogate pairs are not split.
+func truncateTooltip(tooltip string) string {
+	if len(utf16.Encode([]rune(tooltip))) <= maxTooltipLen {
+		return tooltip
+	}
+	length := 0
+	for i, r := range tooltip {
+		length += len(utf16.Encode([]rune{r}))
+		if length > maxTooltipLen-1 { // leave room for "…"
+			return tooltip[:i] + "…"
+		}
+	}
+	return tooltip
+}
+
+// Title of the status menu item of a preset.
+func (a *SystrayApp) statusTitle(presetIndex int) string {
+	status := a.statuses[presetIndex]
+	pending := a.presetPendingRestarts[presetIndex]
+	if status != statusCrashed || pending == nil {
+		return string(status)
+	}
+	secondsLeft := int(math.Ceil(time.Until(pending.at).Seconds()))
+	if secondsLeft < 0 {
+		secondsLeft = 0
+	}
+	return fmt.Sprintf("Kanata Status: Crashed, restarting in %ds [%s] (click to restart now)", secondsLeft, formatAttempt(pending.attempt, pending.maxAttempts))
+}
+
+// Restarts a preset that has exited, immediately or after a delay,
+// as long as restart policy of the preset allows it.
+func (a *SystrayApp) autorestart(presetIndex int) {
+	policy := a.presets[presetIndex].Preset.AutorestartPolicy
+	attempt, delay, isAllowed := a.presetAutorestartLimiter[presetIndex].BeginAttempt(policy)
+	if !isAllowed {
+		a.giveUpAutorestart(presetIndex, fmt.Sprintf("Kanata was restarted %d times within %s and keeps exiting.", policy.MaxAttempts, policy.Window))
+		return
+	}
+	if a.presets[presetIndex].validationErr() != nil {
+		a.giveUpAutorestart(presetIndex, "Preset can't be run with the current config.")
+		return
+	}
+	a.presetRestartCounts[presetIndex] += 1
+	if delay == 0 {
+		logging.Infof(a.presetLogFields(presetIndex).With("event", "autorestart"), "[autorestart-on-crash] Restarting [%s]", formatAttempt(attempt, policy.MaxAttempts))
+		a.runPreset(presetIndex)
+		return
+	}
+	logging.Infof(a.presetLogFields(presetIndex).With("event", "autorestart_scheduled"), "[autorestart-on-crash] Restarting in %s [%s]", delay, formatAttempt(attempt, policy.MaxAttempts))
+	a.presetPendingRestarts[presetIndex] = &pendingRestart{
+		at:          time.Now().Add(delay),
+		attempt:     attempt,
+		maxAttempts: policy.MaxAttempts,
+	}
+	a.mPresetStatuses[presetIndex].SetTitle(a.statusTitle(presetIndex))
+}
+
+// Stops automatic restarts of a preset, until it's started manually.
+// `reason` - a sentence describing why restarts are stopped.
+func (a *SystrayApp) giveUpAutorestart(presetIndex int, reason string) {
+	logging.Warnf(a.presetLogFields(presetIndex).With("event", "autorestart_stopped"), "[autorestart-on-crash] %s Stopping futher attempts.", reason)
+	a.presetAutorestartLimiter[presetIndex].Clear()
+	a.notifyRestartGiveUp(presetIndex, reason)
+	a.mPresetStatuses[presetIndex].SetTitle(a.statusTitle(presetIndex))
+}
+
+// Runs presets with due autorestarts and updates countdowns of the other ones.
+func (a *SystrayApp) processPendingRestarts() {
+	for i, pending := range a.presetPendingRestarts {
+		if pending == nil {
+			continue
+		}
+		if time.Now().Before(pending.at) {
+			a.mPresetStatuses[i].SetTitle(a.statusTitle(i))
+			continue
+		}
+		logging.Infof(a.presetLogFields(i).With("event", "autorestart"), "[autorestart-on-crash] Restarting preset '%s' [%s]", a.presets[i].PresetName, formatAttempt(pending.attempt, pending.maxAttempts))
+		a.runPreset(i)
+	}
+}
+
+func (a *SystrayApp) cancelPendingRestart(presetIndex int) {
+	if a.presetPendingRestarts[presetIndex] == nil {
+		return
+	}
+	logging.Infof(a.presetLogFields(presetIndex), "[autorestart-on-crash] Cancelled pending restart of preset '%s'", a.presets[presetIndex].PresetName)
+	a.presetPendingRestarts[presetIndex] = nil
+	a.mPresetStatuses[presetIndex].SetTitle(a.statusTitle(presetIndex))
+}
+
+type layerMenuClick struct {
+	presetIndex int
+	layerIndex  int
+}
+
+// Returns the name of the clicked layer, or false if the click no longer
+// matches a layer (e.g. preset was removed by config reload).
+func (a *SystrayApp) clickedLayer(click layerMenuClick) (string, bool) {
+	if click.presetIndex >= len(a.presets) || click.layerIndex >= len(a.presetLayerNames[click.presetIndex]) {
+		return "", false
+	}
+	return a.presetLayerNames[click.presetIndex][click.layerIndex], true
+}
+
+type layerMenuEntry struct {
+	title   string
+	checked bool
+}
+
+// Returns items of "Layers" submenu of a preset: layers reported by kanata,
+// with the active layer checked. Returns nil if the submenu should be hidden,
+// because preset is not running or layer names are unknown.
+func layerMenuEntries(status KanataStatus, layerNames []string, currentLayer string) []layerMenuEntry {
+	if status != statusRunning {
+		return nil
+	}
+	var entries []layerMenuEntry
+	for _, layerName := range layerNames {
+		entries = append(entries, layerMenuEntry{title: layerName, checked: layerName == currentLayer})
+	}
+	return entries
+}
+
+// Updates "Layers" submenu of a preset, see `layerMenuEntries`.
+func (a *SystrayApp) refreshLayersMenu(presetIndex int) {
+	layersMenu := a.mPresetLayersMenus[presetIndex]
+	entries := layerMenuEntries(a.statuses[presetIndex], a.presetLayerNames[presetIndex], a.presetCurrentLayers[presetIndex])
+	if len(entries) == 0 {
+		layersMenu.Hide()
+		return
+	}
+	for len(a.mPresetLayers[presetIndex]) < len(entries) {
+		layerIndex := len(a.mPresetLayers[presetIndex])
+		item := layersMenu.AddSubMenuItemCheckbox("", "Switch to this layer", false)
+		a.mPresetLayers[presetIndex] = append(a.mPresetLayers[presetIndex], item)
+		go func() {
+			for range item.ClickedCh {
+				a.changeLayerCh <- layerMenuClick{presetIndex: presetIndex, layerIndex: layerIndex}
+			}
+		}()
+	}
+	for layerIndex, item := range a.mPresetLayers[presetIndex] {
+		if layerIndex >= len(entries) {
+			item.Hide()
+			continue
+		}
+		item.SetTitle(entries[layerIndex].title)
+		if entries[layerIndex].checked {
+			item.Check()
+		} else {
+			item.Uncheck()
+		}
+		item.Show()
+	}
+	layersMenu.Show()
 }
 
 // Cancels (stops) preset at given index, releasing immediately (non-blocking).
@@ -355,22 +905,17 @@ func (a *SystrayApp) cancel(presetIndex int) {
 	a.presetCancelFuncs[presetIndex] = nil
 }
 
+// Sets a static tray icon, replacing any running animation.
 func (a *SystrayApp) setIcon(iconBytes []byte) {
-	a.currentIconData = iconBytes
-	systray.SetIcon(iconBytes)
+	a.iconAnimator.Show(staticIcon(iconBytes))
 }
 
-// Returns a channel that sends an index of item that was clicked.
+// Sends `index` to `ch` every time the menu item is clicked.
 // TODO: pass ctx and cleanup on ctx cancel.
-func multipleMenuItemsClickListener(menuItems []*systray.MenuItem) chan int {
-	ch := make(chan int)
-	for i := range menuItems {
-		i := i
-		go func() {
-			for range menuItems[i].ClickedCh {
-				ch <- i
-			}
-		}()
-	}
-	return ch
+func listenForClicks(menuItem *systray.MenuItem, index int, ch chan<- int) {
+	go func() {
+		for range menuItem.ClickedCh {
+			ch <- index
+		}
+	}()
 }
@@ -2,34 +2,84 @@ package app
 
 import (
 	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+	"github.com/rszyma/kanata-tray/runner/tcp_client"
 )
 
-func (a *SystrayApp) StopPreset(presetName string) error {
-	i, err := a.indexFromPresetName(presetName)
-	if err != nil {
-		return fmt.Errorf("app.indexFromPresetName: %v", err)
+func (a *SystrayApp) TrayStatus() control_api.TrayStatus {
+	respCh := make(chan control_api.TrayStatus)
+	a.statusRequestCh <- respCh
+	return <-respCh
+}
+
+// Returns statuses of all presets, in the order they are declared in config.
+func (a *SystrayApp) PresetStatuses() []control_api.PresetStatus {
+	return a.TrayStatus().Presets
+}
+
+func (a *SystrayApp) PresetStatus(presetName string) (control_api.PresetStatus, error) {
+	for _, status := range a.PresetStatuses() {
+		if status.Name == presetName {
+			return status, nil
+		}
 	}
-	a.stopPresetChan <- i
-	return nil
+	return control_api.PresetStatus{}, fmt.Errorf("preset with the specified name doesn't exist")
+}
+
+// Runs `fn` in the processing loop and waits until it returns. Presets and
+// their statuses may only be accessed from there, because config reload
+// replaces them.
+func (a *SystrayApp) runInLoop(fn func()) {
+	done := make(chan struct{})
+	a.apiRequestCh <- func() {
+		defer close(done)
+		fn()
+	}
+	<-done
+}
+
+func (a *SystrayApp) StopPreset(presetName string) error {
+	var err error
+	a.runInLoop(func() {
+		var i int
+		i, err = a.indexFromPresetName(presetName)
+		if err != nil {
+			err = fmt.Errorf("app.indexFromPresetName: %v", err)
+			return
+		}
+		a.stopPreset(i)
+	})
+	return err
 }
 
 func (a *SystrayApp) StopAllPresets() error {
-	for i, status := range a.statuses {
-		switch status {
-		case statusRunning, statusStarting:
-			a.stopPresetChan <- i
+	a.runInLoop(func() {
+		for i := range a.presets {
+			// Also cancels pending autorestarts of crashed presets.
+			a.stopPreset(i)
 		}
-	}
+	})
 	return nil
 }
 
 func (a *SystrayApp) StartPreset(presetName string) error {
-	i, err := a.indexFromPresetName(presetName)
-	if err != nil {
-		return fmt.Errorf("app.indexFromPresetName: %v", err)
-	}
-	a.startPresetCh <- i
-	return nil
+	var err error
+	a.runInLoop(func() {
+		var i int
+		i, err = a.indexFromPresetName(presetName)
+		if err != nil {
+			err = fmt.Errorf("app.indexFromPresetName: %v", err)
+			return
+		}
+		if err = a.presets[i].validationErr(); err != nil {
+			return
+		}
+		a.startPreset(i)
+	})
+	return err
 }
 
 func (a *SystrayApp) StartAllDefaultPresets() error {
@@ -38,38 +88,149 @@ func (a *SystrayApp) StartAllDefaultPresets() error {
 }
 
 func (a *SystrayApp) TogglePreset(presetName string) (msg string, err error) {
-	i, err := a.indexFromPresetName(presetName)
-	if err != nil {
-		return "", fmt.Errorf("app.indexFromPresetName: %v", err)
-	}
-	switch a.statuses[i] {
-	case statusRunning, statusStarting:
-		a.startPresetCh <- i
-		return "started", nil
-	case statusIdle, statusCrashed:
-		a.stopPresetChan <- i
-		return "stopped", nil
-	}
-	panic("unreachable")
+	a.runInLoop(func() {
+		var i int
+		i, err = a.indexFromPresetName(presetName)
+		if err != nil {
+			err = fmt.Errorf("app.indexFromPresetName: %v", err)
+			return
+		}
+		switch a.statuses[i] {
+		case statusRunning, statusStarting:
+			a.stopPreset(i)
+			msg = "stopped"
+		case statusIdle, statusCrashed:
+			if err = a.presets[i].validationErr(); err != nil {
+				return
+			}
+			a.startPreset(i)
+			msg = "started"
+		}
+	})
+	return msg, err
 }
 
 // If any default preset is running, stop them.
 // If 0 default presets are running, start all default presets.
 func (a *SystrayApp) ToggleAllDefaultPresets() (msg string, err error) {
-	stoppedPresetsCount := 0
-	for i, preset := range a.presets {
-		status := a.statuses[i]
-		if preset.Preset.Autorun && (status == statusRunning || status == statusStarting) {
-			a.stopPresetChan <- i
-			stoppedPresetsCount += 1
+	a.runInLoop(func() {
+		stoppedPresetsCount := 0
+		for i, preset := range a.presets {
+			status := a.statuses[i]
+			if preset.Preset.Autorun && (status == statusRunning || status == statusStarting) {
+				a.stopPreset(i)
+				stoppedPresetsCount += 1
+			}
 		}
-	}
 
-	if stoppedPresetsCount > 0 {
-		return fmt.Sprintf("stopped %d presets", stoppedPresetsCount), nil
+		if stoppedPresetsCount > 0 {
+			msg = fmt.Sprintf("stopped %d presets", stoppedPresetsCount)
+			return
+		}
+
+		a.autorun()
+		msg = "started all default presets"
+	})
+	return msg, nil
+}
+
+// Replaces presets, layer icons and general options with the ones from
+// a reloaded config. Fields of `opts` not derived from config are ignored.
+func (a *SystrayApp) ReloadConfig(opts Opts) {
+	a.reloadConfigCh <- opts
+}
+
+// Subscribes to preset status changes and kanata events. Events are delivered
+// to all subscribers. The returned `unsubscribe` must be called when the
+// subscriber is no longer interested in events.
+func (a *SystrayApp) SubscribeEvents() (events <-chan control_api.Event, unsubscribe func()) {
+	return a.events.subscribe()
+}
+
+// How long SendClientMessage waits for kanata to respond. Older kanata versions
+// don't respond to client messages at all.
+const clientMessageResponseTimeout = time.Second
+
+// Sends a command to kanata running for the given preset and waits until
+// kanata responds to it. Returns an error if kanata rejects the command.
+//
+// Messages requesting information from kanata (e.g. RequestCurrentLayerName)
+// are not accepted, because responses to them are not returned. Current layer
+// is available in preset status and layer names in events instead.
+func (a *SystrayApp) SendClientMessage(presetName string, msg tcp_client.ClientMessage) error {
+	err := msg.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid message: %v", err)
+	}
+	if msg.IsRequest() {
+		return fmt.Errorf("invalid message: requests for information are not supported, current layer is reported in preset status and layer names in events")
+	}
+	respCh := make(chan tcp_client.ServerMessage, 1)
+	a.runInLoop(func() {
+		err = a.runner.SendClientMessage(presetName, msg)
+		if err == nil {
+			a.clientMessageWaiters[presetName] = append(a.clientMessageWaiters[presetName], respCh)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(clientMessageResponseTimeout)
+	defer timer.Stop()
+	var resp tcp_client.ServerMessage
+	var ok bool
+	select {
+	case resp, ok = <-respCh:
+	case <-timer.C:
+		timedOut := false
+		a.runInLoop(func() {
+			waiters := a.clientMessageWaiters[presetName]
+			if i := slices.Index(waiters, respCh); i != -1 {
+				a.clientMessageWaiters[presetName] = slices.Delete(waiters, i, i+1)
+				timedOut = true
+			}
+		})
+		if timedOut {
+			// Kanata is probably too old to respond.
+			return nil
+		}
+		// Response was delivered after the timeout fired.
+		resp, ok = <-respCh
 	}
+	if !ok {
+		return fmt.Errorf("kanata exited before responding")
+	}
+	if resp.ResponseStatus == "Error" {
+		return fmt.Errorf("kanata rejected the message: %s", resp.ResponseMsg)
+	}
+	return nil
+}
 
-	a.Autorun()
+// Passes a response of kanata to the oldest client message of the preset that
+// awaits one. Kanata responds to messages in the order they were sent.
+//
+// Must be called from the processing loop.
+func (a *SystrayApp) deliverClientMessageResponse(presetName string, resp tcp_client.ServerMessage) {
+	waiters := a.clientMessageWaiters[presetName]
+	if len(waiters) == 0 {
+		return
+	}
+	// nil if the message was sent by kanata-tray itself
+	if waiters[0] != nil {
+		waiters[0] <- resp
+	}
+	a.clientMessageWaiters[presetName] = waiters[1:]
+}
 
-	return "started all default presets", nil
+// Tells senders of client messages that are still awaiting a response, that
+// kanata of the preset exited.
+//
+// Must be called from the processing loop.
+func (a *SystrayApp) dropClientMessageWaiters(presetName string) {
+	for _, respCh := range a.clientMessageWaiters[presetName] {
+		if respCh != nil {
+			close(respCh)
+		}
+	}
+	delete(a.clientMessageWaiters, presetName)
 }
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestTruncateTooltip(t *testing.T) {
+	short := "main: base (running)"
+	if got := truncateTooltip(short); got != short {
+		t.Errorf("truncateTooltip(%q) = %q, want it unchanged", short, got)
+	}
+	exact := strings.Repeat("a", maxTooltipLen)
+	if got := truncateTooltip(exact); got != exact {
+		t.Errorf("tooltip of exactly %d units was truncated", maxTooltipLen)
+	}
+
+	for _, long := range []string{
+		strings.Repeat("a", 200),
+		strings.Repeat("ą", 200),
+		strings.Repeat("😀", 100), // each is a surrogate pair in UTF-16
+		"a" + strings.Repeat("😀", 100),
+	} {
+		got := truncateTooltip(long)
+		units := utf16.Encode([]rune(got))
+		if len(units) > maxTooltipLen {
+			t.Errorf("truncated tooltip has %d UTF-16 units, want at most %d", len(units), maxTooltipLen)
+		}
+		if !strings.HasSuffix(got, "…") || !strings.HasPrefix(long, strings.TrimSuffix(got, "…")) {
+			t.Errorf("truncateTooltip(%q) = %q, want a prefix ending with \"…\"", long, got)
+		}
+		if strings.ContainsRune(got, '�') {
+			t.Errorf("truncateTooltip(%q) split a character: %q", long, got)
+		}
+	}
+}
+
+func TestLayerMenuEntries(t *testing.T) {
+	layerNames := []string{"base", "nav", "sym"}
+	got := layerMenuEntries(statusRunning, layerNames, "nav")
+	want := []layerMenuEntry{{"base", false}, {"nav", true}, {"sym", false}}
+	if !slices.Equal(got, want) {
+		t.Errorf("layerMenuEntries = %+v, want %+v", got, want)
+	}
+	// Current layer may be unknown, e.g. right after start.
+	for _, entry := range layerMenuEntries(statusRunning, layerNames, "") {
+		if entry.checked {
+			t.Errorf("layer '%s' is checked, but current layer is unknown", entry.title)
+		}
+	}
+	for _, status := range []KanataStatus{statusIdle, statusStarting, statusCrashed} {
+		if got := layerMenuEntries(status, layerNames, "base"); got != nil {
+			t.Errorf("layerMenuEntries for %s preset = %+v, want nil", status, got)
+		}
+	}
+	if got := layerMenuEntries(statusRunning, nil, ""); got != nil {
+		t.Errorf("layerMenuEntries with unknown layer names = %+v, want nil", got)
+	}
+}
+
+func TestClickedLayer(t *testing.T) {
+	a := &SystrayApp{
+		presets:          []PresetMenuEntry{{PresetName: "main"}, {PresetName: "other"}},
+		presetLayerNames: [][]string{{"base", "nav"}, nil},
+	}
+	if layer, ok := a.clickedLayer(layerMenuClick{presetIndex: 0, layerIndex: 1}); !ok || layer != "nav" {
+		t.Errorf("clickedLayer = %q, %v, want \"nav\"", layer, ok)
+	}
+	// Menu items may outlive layers and presets they were created for.
+	for _, click := range []layerMenuClick{{0, 2}, {1, 0}, {2, 0}} {
+		if layer, ok := a.clickedLayer(click); ok {
+			t.Errorf("clickedLayer(%+v) = %q, want no layer", click, layer)
+		}
+	}
+}
@@ -1,28 +1,53 @@
 package app
 
-import "time"
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rszyma/kanata-tray/config"
+)
 
 // TODO: rename to RateLimiter (+also rename BeginAttempt)
 type RestartLimiter struct {
 	recentRestarts []time.Time
 }
 
-const AutorestartLimit = 2
-const AutorestartWindow = 60 * time.Second
-
-func (rt *RestartLimiter) BeginAttempt() (attempt int, isAllowed bool) {
-	rt.cleanupOld()
-	if len(rt.recentRestarts) >= AutorestartLimit {
-		return 0, false
+// Registers a restart attempt, if it's allowed by the policy.
+// Returns the attempt number within policy window and how long
+// to wait before restarting.
+func (rt *RestartLimiter) BeginAttempt(policy config.RestartPolicy) (attempt int, delay time.Duration, isAllowed bool) {
+	rt.cleanupOld(policy.Window)
+	if policy.MaxAttempts != 0 && len(rt.recentRestarts) >= policy.MaxAttempts {
+		return 0, 0, false
 	}
 	rt.recentRestarts = append(rt.recentRestarts, time.Now())
-	return len(rt.recentRestarts), true
+	attempt = len(rt.recentRestarts)
+	return attempt, backoffDelay(policy, attempt), true
+}
+
+// Delay before `attempt`-th restart. `policy.MaxDelay` of 0 means no cap.
+func backoffDelay(policy config.RestartPolicy, attempt int) time.Duration {
+	delay := float64(policy.InitialDelay) * math.Pow(policy.BackoffMultiplier, float64(attempt-1))
+	maxDelay := float64(math.MaxInt64)
+	if policy.MaxDelay != 0 {
+		maxDelay = float64(policy.MaxDelay)
+	}
+	// Clamp before converting, because float64 out of int64 range doesn't
+	// convert to a valid Duration.
+	if !(delay < maxDelay) { // also true for NaN
+		if policy.MaxDelay != 0 {
+			return policy.MaxDelay
+		}
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
 }
 
-func (rt *RestartLimiter) cleanupOld() {
+func (rt *RestartLimiter) cleanupOld(window time.Duration) {
 	filtered := []time.Time{}
 	for _, t := range rt.recentRestarts {
-		if time.Since(t) > AutorestartWindow {
+		if time.Since(t) > window {
 			continue
 		}
 		filtered = append(filtered, t)
@@ -33,3 +58,18 @@ func (rt *RestartLimiter) cleanupOld() {
 func (rt *RestartLimiter) Clear() {
 	rt.recentRestarts = nil
 }
+
+// Formats attempt number as "3/5", or just "3" if number of attempts is not limited.
+func formatAttempt(attempt int, maxAttempts int) string {
+	if maxAttempts == 0 {
+		return fmt.Sprint(attempt)
+	}
+	return fmt.Sprintf("%d/%d", attempt, maxAttempts)
+}
+
+// A restart that will happen after a delay.
+type pendingRestart struct {
+	at          time.Time
+	attempt     int
+	maxAttempts int // 0 means no limit
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rszyma/kanata-tray/config"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	policy := config.RestartPolicy{
+		InitialDelay:      time.Second,
+		BackoffMultiplier: 2,
+		MaxDelay:          10 * time.Second,
+	}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := backoffDelay(policy, tt.attempt); got != tt.want {
+			t.Errorf("backoffDelay(attempt=%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffDelayNoMaxDelay(t *testing.T) {
+	policy := config.RestartPolicy{InitialDelay: time.Second, BackoffMultiplier: 3}
+	if got, want := backoffDelay(policy, 4), 27*time.Second; got != want {
+		t.Errorf("backoffDelay = %s, want %s", got, want)
+	}
+}
+
+func TestBackoffDelayOverflow(t *testing.T) {
+	policy := config.RestartPolicy{InitialDelay: time.Second, BackoffMultiplier: 2}
+	for _, attempt := range []int{64, 100, 2000} {
+		if got := backoffDelay(policy, attempt); got != time.Duration(math.MaxInt64) {
+			t.Errorf("backoffDelay(attempt=%d) without max delay = %s, want max Duration", attempt, got)
+		}
+	}
+	policy.MaxDelay = time.Minute
+	if got := backoffDelay(policy, 2000); got != time.Minute {
+		t.Errorf("backoffDelay(attempt=2000) = %s, want %s", got, time.Minute)
+	}
+}
+
+func TestRestartLimiter(t *testing.T) {
+	policy := config.RestartPolicy{
+		MaxAttempts:       3,
+		Window:            time.Minute,
+		InitialDelay:      time.Second,
+		BackoffMultiplier: 2,
+	}
+	var limiter RestartLimiter
+	for i := 1; i <= 3; i++ {
+		attempt, delay, isAllowed := limiter.BeginAttempt(policy)
+		if !isAllowed || attempt != i {
+			t.Fatalf("BeginAttempt #%d = (%d, %t), want (%d, true)", i, attempt, isAllowed, i)
+		}
+		if want := backoffDelay(policy, i); delay != want {
+			t.Errorf("BeginAttempt #%d delay = %s, want %s", i, delay, want)
+		}
+	}
+	if _, _, isAllowed := limiter.BeginAttempt(policy); isAllowed {
+		t.Fatalf("BeginAttempt allowed more than MaxAttempts restarts")
+	}
+
+	// Restarts outside of the window don't count.
+	for i := range limiter.recentRestarts {
+		limiter.recentRestarts[i] = limiter.recentRestarts[i].Add(-2 * time.Minute)
+	}
+	if attempt, _, isAllowed := limiter.BeginAttempt(policy); !isAllowed || attempt != 1 {
+		t.Fatalf("BeginAttempt after window = (%d, %t), want (1, true)", attempt, isAllowed)
+	}
+
+	limiter.Clear()
+	if attempt, _, _ := limiter.BeginAttempt(policy); attempt != 1 {
+		t.Fatalf("BeginAttempt after Clear = %d, want 1", attempt)
+	}
+}
+
+func TestRestartLimiterUnlimited(t *testing.T) {
+	policy := config.RestartPolicy{Window: time.Minute}
+	var limiter RestartLimiter
+	for i := 1; i <= 100; i++ {
+		if _, _, isAllowed := limiter.BeginAttempt(policy); !isAllowed {
+			t.Fatalf("BeginAttempt #%d not allowed with MaxAttempts = 0", i)
+		}
+	}
+}
+
+func TestFormatAttempt(t *testing.T) {
+	tests := []struct {
+		attempt, maxAttempts int
+		want                 string
+	}{
+		{1, 5, "1/5"},
+		{5, 5, "5/5"},
+		{3, 0, "3"},
+	}
+	for _, tt := range tests {
+		if got := formatAttempt(tt.attempt, tt.maxAttempts); got != tt.want {
+			t.Errorf("formatAttempt(%d, %d) = %q, want %q", tt.attempt, tt.maxAttempts, got, tt.want)
+		}
+	}
+}
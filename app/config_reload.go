@@ -0,0 +1,149 @@
+package app
+
+import (
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/getlantern/systray"
+
+	"github.com/rszyma/kanata-tray/logging"
+)
+
+// Applies a reloaded config. Presets are matched by name:
+//   - presets removed from config are stopped,
+//   - running presets with changed settings are restarted,
+//   - all other running presets are kept alive.
+//
+// Must be called from the processing loop.
+func (a *SystrayApp) applyConfig(opts Opts) {
+	newPresets := opts.MenuTemplate
+	logPresetDiagnostics(newPresets)
+
+	newIndexByName := make(map[string]int, len(newPresets))
+	for j, entry := range newPresets {
+		newIndexByName[entry.PresetName] = j
+	}
+
+	// For each new preset, an index of a preset with the same name in
+	// the previous config, or -1 if it's a new preset.
+	oldIndices := make([]int, len(newPresets))
+	for j, entry := range newPresets {
+		oldIndices[j] = -1
+		if i, err := a.indexFromPresetName(entry.PresetName); err == nil {
+			oldIndices[j] = i
+		}
+	}
+
+	for i, entry := range a.presets {
+		if _, ok := newIndexByName[entry.PresetName]; ok {
+			continue
+		}
+		logging.Infof(a.presetLogFields(i), "Preset '%s' was removed from config", entry.PresetName)
+		a.cancel(i)
+		if f := a.presetLogFiles[i]; f != nil {
+			f.Close()
+		}
+	}
+
+	scheduledPresetName := ""
+	if a.scheduledPresetIndex != -1 {
+		scheduledPresetName = a.presets[a.scheduledPresetIndex].PresetName
+	}
+
+	a.statuses = remapPresetState(a.statuses, oldIndices, statusIdle)
+	a.presetCancelFuncs = remapPresetState(a.presetCancelFuncs, oldIndices, nil)
+	a.presetAutorestartLimiter = remapPresetState(a.presetAutorestartLimiter, oldIndices, RestartLimiter{})
+	a.presetLogFiles = remapPresetState(a.presetLogFiles, oldIndices, nil)
+	a.presetRestartOnExit = remapPresetState(a.presetRestartOnExit, oldIndices, false)
+	a.presetCurrentLayers = remapPresetState(a.presetCurrentLayers, oldIndices, "")
+	a.presetLastErrors = remapPresetState(a.presetLastErrors, oldIndices, nil)
+	a.presetRestartCounts = remapPresetState(a.presetRestartCounts, oldIndices, 0)
+	a.presetLayerNames = remapPresetState(a.presetLayerNames, oldIndices, nil)
+	a.presetPendingRestarts = remapPresetState(a.presetPendingRestarts, oldIndices, nil)
+	a.presetLastActivity = remapPresetState(a.presetLastActivity, oldIndices, time.Time{})
+
+	oldPresets := a.presets
+	a.presets = newPresets
+	a.layerIcons = opts.LayerIcons
+	a.concurrentPresets = opts.AllowConcurrentPresets
+	// Applies to kanata logs of presets started from now on.
+	a.kanataLogMaxSize = opts.KanataLogMaxSize
+	a.kanataLogMaxFiles = opts.KanataLogMaxFiles
+	a.notifyOpts = opts.Notify
+	a.animationOpts = opts.IconAnimations
+	a.iconPolicy = opts.IconPolicy
+	// Layer icons or icon policy may have changed.
+	a.shownIconKey = ""
+	a.tooltipTemplate = opts.Tooltip
+	a.menuTitleTemplate = opts.MenuTitle
+
+	a.scheduledPresetIndex = -1
+	if j, ok := newIndexByName[scheduledPresetName]; ok {
+		a.scheduledPresetIndex = j
+	}
+
+	for j, i := range oldIndices {
+		if i == -1 {
+			continue
+		}
+		if reflect.DeepEqual(oldPresets[i].Preset, newPresets[j].Preset) {
+			continue
+		}
+		switch a.statuses[j] {
+		case statusRunning, statusStarting:
+			logging.Infof(logging.Fields{"preset": newPresets[j].PresetName}, "Settings of preset '%s' changed, restarting it", newPresets[j].PresetName)
+			a.presetRestartOnExit[j] = true
+			a.cancel(j)
+		case statusIdle, statusCrashed: // will use new settings on next run
+		}
+	}
+
+	if !a.concurrentPresets {
+		// `allow_concurrent_presets` may have just been disabled,
+		// so only the first running preset is kept.
+		kept := slices.IndexFunc(a.statuses, func(status KanataStatus) bool {
+			return status == statusRunning || status == statusStarting
+		})
+		for i := range a.presets {
+			isActive := a.statuses[i] == statusRunning || a.statuses[i] == statusStarting || a.presetPendingRestarts[i] != nil
+			if kept == -1 || i == kept || !isActive {
+				continue
+			}
+			logging.Infof(a.presetLogFields(i), "Stopping preset '%s', because concurrent presets are not allowed", a.presets[i].PresetName)
+			a.presetRestartOnExit[i] = false
+			a.stopPreset(i)
+		}
+	}
+
+	if len(a.presets) > len(a.mPresets) {
+		// New menu items can only be appended at the end of the menu, so
+		// footer items have to be recreated below them. The old separator
+		// can't be removed and will stay above the new presets.
+		a.mOptions.Hide()
+		a.mShowLogs.Hide()
+		a.mQuit.Hide()
+		for len(a.mPresets) < len(a.presets) {
+			a.addPresetMenuSlot()
+		}
+		systray.AddSeparator()
+		a.addFooterMenuItems()
+	}
+	a.refreshPresetMenuSlots()
+
+	logging.Infof(logging.Fields{"event": "config_reloaded"}, "Config reloaded (%d presets)", len(a.presets))
+}
+
+// Returns a new slice where value at index `j` is `xs[oldIndices[j]]`,
+// or `zero` if `oldIndices[j]` is -1.
+func remapPresetState[T any](xs []T, oldIndices []int, zero T) []T {
+	result := make([]T, len(oldIndices))
+	for j, i := range oldIndices {
+		if i == -1 {
+			result[j] = zero
+		} else {
+			result[j] = xs[i]
+		}
+	}
+	return result
+}
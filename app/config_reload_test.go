@@ -0,0 +1,18 @@
+package app
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRemapPresetState(t *testing.T) {
+	old := []string{"a", "b", "c"}
+	// "b" was removed, "c" moved to the front, and a new preset was appended.
+	got := remapPresetState(old, []int{2, 0, -1}, "new")
+	if want := []string{"c", "a", "new"}; !slices.Equal(got, want) {
+		t.Errorf("remapPresetState = %v, want %v", got, want)
+	}
+	if got := remapPresetState(old, nil, ""); len(got) != 0 {
+		t.Errorf("remapPresetState with no presets = %v, want empty", got)
+	}
+}
@@ -0,0 +1,108 @@
+// Package control_api contains types and helpers shared by the control
+// server and its clients (`kanata-tray ctl`). It must not depend on systray,
+// so that clients can be built without it.
+package control_api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rszyma/kanata-tray/config"
+)
+
+type GenericResponse[T any] struct {
+	IsSuccess bool
+	Message   string `json:",omitempty"`
+	Data      T      `json:",omitempty"`
+}
+
+type PresetStatus struct {
+	Name         string
+	Status       string // one of: "idle", "starting", "running", "crashed"
+	Pid          int    `json:",omitempty"`
+	TcpPort      int    // 0 if the port is picked automatically and the preset is not running
+	CurrentLayer string `json:",omitempty"`
+	LastError    string `json:",omitempty"`
+	// Problems with preset configuration. Presets with errors can't be started.
+	Diagnostics  []string `json:",omitempty"`
+	RestartCount int
+	LogFile      string `json:",omitempty"`
+}
+
+type TrayStatus struct {
+	AllowConcurrentPresets bool
+	RunningPresets         []string
+	Presets                []PresetStatus
+}
+
+type EventType string
+
+const (
+	EventStatus           EventType = "status"             // preset status changed
+	EventLayerChange      EventType = "layer_change"       // kanata switched active layer
+	EventLayerNames       EventType = "layer_names"        // kanata reported names of all layers
+	EventConfigFileReload EventType = "config_file_reload" // kanata reloaded its config file
+)
+
+type Event struct {
+	Type   EventType
+	Preset string
+	Time   time.Time
+	Status string   `json:",omitempty"` // set for EventStatus
+	Error  string   `json:",omitempty"` // set for EventStatus when preset exited with an error
+	Layer  string   `json:",omitempty"` // set for EventLayerChange
+	Layers []string `json:",omitempty"` // set for EventLayerNames
+}
+
+const TokenHeaderPrefix = "Bearer "
+
+// Name of the file in config folder, where generated token is stored.
+const TokenFileName = "control_server_token"
+
+// Returns socket path that should be used when `control_server_socket_path`
+// is not set: $XDG_RUNTIME_DIR/kanata-tray.sock if $XDG_RUNTIME_DIR is set,
+// otherwise kanata-tray.sock in config folder.
+func DefaultSocketPath(configFolder string) string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "kanata-tray.sock")
+	}
+	return filepath.Join(configFolder, "kanata-tray.sock")
+}
+
+// Returns the token a client should send to control server, or empty string
+// if token is not required. Unlike the server, doesn't generate a token
+// if there's none yet.
+func ReadToken(general config.GeneralConfigOptions, configFolder string) (string, error) {
+	if !general.ControlServerRequireToken {
+		return "", nil
+	}
+	if general.ControlServerToken != "" {
+		return general.ControlServerToken, nil
+	}
+	path := filepath.Join(configFolder, TokenFileName)
+	token, err := ReadTokenFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("control server requires a token, but token file '%s' doesn't exist. "+
+			"It's created when kanata-tray starts the control server", path)
+	}
+	return token, err
+}
+
+// Reads a token from file. Returned error wraps fs.ErrNotExist if
+// the file doesn't exist.
+func ReadTokenFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("token file '%s' is empty", path)
+	}
+	return token, nil
+}
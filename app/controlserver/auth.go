@@ -0,0 +1,104 @@
+package controlserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/logging"
+	"github.com/rszyma/kanata-tray/os_specific"
+)
+
+// Rejects requests that don't carry the expected token, either in
+// `Authorization: Bearer <token>` header or in `token` query parameter.
+// Empty token disables the check.
+func requireToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" && !hasValidToken(r, token) {
+				writeErrorResponse(w, r, statusError{
+					code: http.StatusUnauthorized,
+					err:  fmt.Errorf("missing or invalid token"),
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func hasValidToken(r *http.Request, token string) bool {
+	provided := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, control_api.TokenHeaderPrefix) {
+		provided = strings.TrimPrefix(header, control_api.TokenHeaderPrefix)
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// Rejects requests made by web browsers. Browsers attach `Origin` header to
+// cross-origin requests, which lets us stop any web page from sending
+// requests to control server. Non-browser clients don't send it.
+func rejectBrowserRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			writeErrorResponse(w, r, statusError{
+				code: http.StatusForbidden,
+				err:  fmt.Errorf("requests from web browsers are not allowed"),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns the token that control server requests must provide, or empty
+// string if token is not required.
+func ResolveToken(general config.GeneralConfigOptions, configFolder string) (string, error) {
+	if !general.ControlServerRequireToken {
+		return "", nil
+	}
+	if general.ControlServerToken != "" {
+		return general.ControlServerToken, nil
+	}
+	return LoadOrCreateTokenFile(filepath.Join(configFolder, control_api.TokenFileName))
+}
+
+// Reads a token from file, or generates a new random token and saves it to
+// the file if the file doesn't exist yet. An existing file must be owned by
+// the current user; if other users can read it, its permissions are fixed.
+func LoadOrCreateTokenFile(path string) (string, error) {
+	if info, err := os.Stat(path); err == nil {
+		err = os_specific.CheckPrivateFile(info)
+		if errors.Is(err, os_specific.ErrFileAccessibleByOthers) {
+			logging.Warnf(nil, "Token file '%s' is accessible by other users (permissions %o), restricting its permissions to 600", path, info.Mode().Perm())
+			err = os.Chmod(path, 0o600)
+		}
+		if err != nil {
+			return "", fmt.Errorf("token file '%s' can't be trusted: %v", path, err)
+		}
+	}
+	token, err := control_api.ReadTokenFile(path)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return token, err
+	}
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	token = hex.EncodeToString(buf)
+	err = os.WriteFile(path, []byte(token+"\n"), 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to write token file: %v", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,147 @@
+package controlserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+	"github.com/rszyma/kanata-tray/config"
+)
+
+func TestRequireToken(t *testing.T) {
+	fake := newFakeApp()
+	opts := ServerOpts{Token: "secret"}
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"missing", "/status", "", http.StatusUnauthorized},
+		{"wrong header", "/status", control_api.TokenHeaderPrefix + "wrong", http.StatusUnauthorized},
+		{"wrong query", "/status?token=wrong", "", http.StatusUnauthorized},
+		{"header", "/status", control_api.TokenHeaderPrefix + "secret", http.StatusOK},
+		{"query", "/status?token=secret", "", http.StatusOK},
+		// Token is checked before looking up the route.
+		{"unknown path", "/unknown", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			code, _ := doRequest[*struct{}](t, fake, opts, req)
+			if code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, code, tt.want)
+			}
+		})
+	}
+
+	// Empty token disables the check.
+	code, _ := doRequest[*struct{}](t, fake, ServerOpts{}, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if code != http.StatusOK {
+		t.Errorf("GET /status without required token = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestRejectBrowserRequests(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/stop_all", nil)
+	req.Header.Set("Origin", "https://example.com")
+	code, resp := doRequest[*struct{}](t, newFakeApp(), ServerOpts{}, req)
+	if code != http.StatusForbidden || resp.IsSuccess {
+		t.Errorf("POST /stop_all from browser = %d %+v, want %d", code, resp, http.StatusForbidden)
+	}
+}
+
+func TestLoadOrCreateTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), control_api.TokenFileName)
+
+	token, err := LoadOrCreateTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 64 {
+		t.Errorf("generated token %q, want 64 hex characters", token)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Errorf("token file permissions = %o, want 600", perm)
+		}
+	}
+
+	again, err := LoadOrCreateTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != token {
+		t.Errorf("token changed after loading it again: %q -> %q", token, again)
+	}
+
+	if err := os.WriteFile(path, []byte("  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateTokenFile(path); err == nil {
+		t.Error("empty token file was accepted")
+	}
+}
+
+func TestLoadOrCreateTokenFileRestrictsPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on windows")
+	}
+	path := filepath.Join(t.TempDir(), control_api.TokenFileName)
+	if err := os.WriteFile(path, []byte("secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile permissions are subject to umask.
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := LoadOrCreateTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "secret" {
+		t.Errorf("token = %q, want %q", token, "secret")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("token file permissions = %o, want 600", perm)
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	configFolder := t.TempDir()
+
+	token, err := ResolveToken(config.GeneralConfigOptions{ControlServerToken: "ignored"}, configFolder)
+	if err != nil || token != "" {
+		t.Errorf("ResolveToken with token not required = %q, %v, want empty", token, err)
+	}
+
+	token, err = ResolveToken(config.GeneralConfigOptions{ControlServerRequireToken: true, ControlServerToken: "from-config"}, configFolder)
+	if err != nil || token != "from-config" {
+		t.Errorf("ResolveToken with token in config = %q, %v, want %q", token, err, "from-config")
+	}
+
+	token, err = ResolveToken(config.GeneralConfigOptions{ControlServerRequireToken: true}, configFolder)
+	if err != nil || token == "" {
+		t.Fatalf("ResolveToken without token in config = %q, %v, want generated token", token, err)
+	}
+	fromFile, err := control_api.ReadTokenFile(filepath.Join(configFolder, control_api.TokenFileName))
+	if err != nil || fromFile != token {
+		t.Errorf("token file contains %q, %v, want %q", fromFile, err, token)
+	}
+}
@@ -0,0 +1,87 @@
+package controlserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+	"github.com/rszyma/kanata-tray/logging"
+)
+
+// Interval of comment lines sent to keep idle connections alive and to
+// detect disconnected clients.
+const eventStreamKeepaliveInterval = 15 * time.Second
+
+// Streams events as server-sent events (https://html.spec.whatwg.org/multipage/server-sent-events.html).
+// Right after connecting, a "status" event is sent for every preset to
+// describe the current state.
+func h_events(w http.ResponseWriter, r *http.Request) {
+	reqCount := globalReqCount.Add(1)
+	logging.Infof(nil, "[req=%d] request received: %s %s", reqCount, r.Method, r.URL.Path)
+
+	rc := http.NewResponseController(w)
+	// The stream is long-lived, so the server-wide write timeout can't apply here.
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		logging.Errorf(nil, "[req=%d] failed to disable write deadline: %v", reqCount, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := app.SubscribeEvents()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, status := range app.PresetStatuses() {
+		err := writeEvent(w, control_api.Event{
+			Type:   control_api.EventStatus,
+			Preset: status.Name,
+			Time:   time.Now(),
+			Status: status.Status,
+			Error:  status.LastError,
+			Layer:  status.CurrentLayer,
+		})
+		if err != nil {
+			logging.Infof(nil, "[req=%d] event stream closed: %v", reqCount, err)
+			return
+		}
+	}
+	rc.Flush()
+
+	keepalive := time.NewTicker(eventStreamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			logging.Infof(nil, "[req=%d] event stream closed by client", reqCount)
+			return
+		case <-keepalive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case event := <-events:
+			err = writeEvent(w, event)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			logging.Infof(nil, "[req=%d] event stream closed: %v", reqCount, err)
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event control_api.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
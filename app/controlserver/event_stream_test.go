@@ -0,0 +1,80 @@
+package controlserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+)
+
+// Reads the next event from a server-sent events stream.
+func readEvent(t *testing.T, r *bufio.Reader) control_api.Event {
+	t.Helper()
+	var eventType string
+	var event control_api.Event
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && eventType != "":
+			if string(event.Type) != eventType {
+				t.Errorf("event name '%s' doesn't match type of event data '%s'", eventType, event.Type)
+			}
+			return event
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("failed to decode event data '%s': %v", line, err)
+			}
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	fake := newFakeApp(
+		control_api.PresetStatus{Name: "main", Status: "running", CurrentLayer: "base"},
+		control_api.PresetStatus{Name: "other", Status: "crashed", LastError: "exit status 1"},
+	)
+	app = fake
+	srv := httptest.NewServer(newRouter(ServerOpts{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %s, want text/event-stream", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	// Current status of every preset is sent first.
+	event := readEvent(t, r)
+	if event.Type != control_api.EventStatus || event.Preset != "main" || event.Status != "running" || event.Layer != "base" {
+		t.Errorf("first event = %+v", event)
+	}
+	event = readEvent(t, r)
+	if event.Type != control_api.EventStatus || event.Preset != "other" || event.Status != "crashed" || event.Error != "exit status 1" {
+		t.Errorf("second event = %+v", event)
+	}
+
+	select {
+	case fake.events <- control_api.Event{Type: control_api.EventLayerChange, Preset: "main", Time: time.Now(), Layer: "nav"}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event stream doesn't receive events")
+	}
+	event = readEvent(t, r)
+	if event.Type != control_api.EventLayerChange || event.Preset != "main" || event.Layer != "nav" {
+		t.Errorf("layer change event = %+v", event)
+	}
+}
@@ -1,50 +1,155 @@
 package controlserver
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/labstack/gommon/log"
-	applib "github.com/rszyma/kanata-tray/app"
+	"github.com/rszyma/kanata-tray/app/control_api"
+	"github.com/rszyma/kanata-tray/logging"
+	"github.com/rszyma/kanata-tray/os_specific"
+	"github.com/rszyma/kanata-tray/runner/tcp_client"
 )
 
-var app *applib.SystrayApp // init in RunControlServer
+// Operations of kanata-tray used by control server, implemented by *app.SystrayApp.
+type App interface {
+	StopPreset(presetName string) error
+	StopAllPresets() error
+	StartPreset(presetName string) error
+	StartAllDefaultPresets() error
+	TogglePreset(presetName string) (msg string, err error)
+	ToggleAllDefaultPresets() (msg string, err error)
+	TrayStatus() control_api.TrayStatus
+	PresetStatuses() []control_api.PresetStatus
+	PresetStatus(presetName string) (control_api.PresetStatus, error)
+	SubscribeEvents() (events <-chan control_api.Event, unsubscribe func())
+	SendClientMessage(presetName string, msg tcp_client.ClientMessage) error
+}
 
-// All possible status codes: 200, 400, 500
+var app App // init in RunControlServer
 
-func RunControlServer(app_ *applib.SystrayApp, port int) error {
-	app = app_
+// All possible status codes: 200, 400, 401, 403, 405, 500
 
-	mux := chi.NewRouter()
+type ServerOpts struct {
+	Transport  string // "tcp" or "unix"
+	Port       int    // used with "tcp" transport
+	SocketPath string // used with "unix" transport
+	SocketMode os.FileMode
+	Token      string // if not empty, all requests are required to provide it
+}
+
+func RunControlServer(app_ App, opts ServerOpts) error {
+	app = app_
 
 	srv := &http.Server{
-		Addr:         fmt.Sprintf("127.0.0.1:%d", port),
-		Handler:      mux,
+		Handler:      newRouter(opts),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	listener, err := listen(opts)
+	if err != nil {
+		return err
+	}
+
+	logging.Infof(nil, "Control server running at %s", listener.Addr())
+
+	return srv.Serve(listener)
+}
+
+func newRouter(opts ServerOpts) http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Use(rejectBrowserRequests)
+	mux.Use(requireToken(opts.Token))
+
 	mux.NotFound(WrapGenericResp(h_notFound))
+	mux.MethodNotAllowed(WrapGenericResp(h_methodNotAllowed))
+
+	// Routes that change state accept only POST requests.
+
+	mux.Post("/stop/{preset_name}", WrapGenericResp(h_stopSpecific))
+	mux.Post("/stop_all", WrapGenericResp(h_stopAll))
+	mux.Post("/start/{preset_name}", WrapGenericResp(h_startSpecific))
+	mux.Post("/start_all_default", WrapGenericResp(h_startAllDefault))
+	mux.Post("/toggle/{preset_name}", WrapGenericResp(h_toggleSpecific))
+	mux.Post("/toggle_all_default", WrapGenericResp(h_toggleAllDefault))
 
-	mux.HandleFunc("/stop/{preset_name}", WrapGenericResp(h_stopSpecific))
-	mux.HandleFunc("/stop_all", WrapGenericResp(h_stopAll))
-	mux.HandleFunc("/start/{preset_name}", WrapGenericResp(h_startSpecific))
-	mux.HandleFunc("/start_all_default", WrapGenericResp(h_startAllDefault))
-	mux.HandleFunc("/toggle/{preset_name}", WrapGenericResp(h_toggleSpecific))
-	mux.HandleFunc("/toggle_all_default", WrapGenericResp(h_toggleAllDefault))
+	mux.Get("/status", WrapGenericResp(h_status))
+	mux.Get("/presets", WrapGenericResp(h_presets))
+	mux.Get("/presets/{preset_name}", WrapGenericResp(h_presetSpecific))
+	mux.Get("/events", h_events)
 
-	log.Infof("Control server running at %s", srv.Addr)
+	mux.Post("/presets/{preset_name}/layer/{layer_name}", WrapGenericResp(h_changeLayer))
+	mux.Post("/presets/{preset_name}/fake_key/{key_name}/{action}", WrapGenericResp(h_actOnFakeKey))
+	mux.Post("/presets/{preset_name}/mouse/{x}/{y}", WrapGenericResp(h_setMouse))
+	mux.Post("/presets/{preset_name}/reload", WrapGenericResp(h_reload))
+	mux.Post("/presets/{preset_name}/reload_next", WrapGenericResp(h_reloadNext))
+	mux.Post("/presets/{preset_name}/reload_prev", WrapGenericResp(h_reloadPrev))
+	mux.Post("/presets/{preset_name}/send", WrapGenericResp(h_send))
 
-	return srv.ListenAndServe()
+	return mux
+}
+
+func listen(opts ServerOpts) (net.Listener, error) {
+	switch opts.Transport {
+	case "tcp":
+		return net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", opts.Port))
+	case "unix":
+		return listenUnix(opts.SocketPath, opts.SocketMode)
+	}
+	return nil, fmt.Errorf("unknown transport '%s'", opts.Transport)
+}
+
+func listenUnix(socketPath string, mode os.FileMode) (net.Listener, error) {
+	if _, err := os.Stat(socketPath); err == nil {
+		// Socket file may be left over from a previous run that didn't exit
+		// cleanly. Remove it, unless there's a live server behind it.
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket '%s' is already in use", socketPath)
+		}
+		err = os.Remove(socketPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+		}
+	}
+	// Socket is created without permissions not included in `mode`, so it's
+	// never accessible to other users, even before chmod.
+	var listener net.Listener
+	var err error
+	os_specific.WithUmask(^mode, func() {
+		listener, err = net.Listen("unix", socketPath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(socketPath, mode)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %v", err)
+	}
+	return listener, nil
 }
 
 func h_notFound[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
 	return nil, "", fmt.Errorf("unrecognized command / invalid request path")
 }
 
+func h_methodNotAllowed[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
+	return nil, "", statusError{
+		code: http.StatusMethodNotAllowed,
+		err:  fmt.Errorf("method %s is not allowed for this path", r.Method),
+	}
+}
+
 func h_stopSpecific[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
 	presetName := chi.URLParam(r, "preset_name")
 	err := app.StopPreset(presetName)
@@ -95,3 +200,86 @@ func h_toggleAllDefault[R *struct{}](w http.ResponseWriter, r *http.Request) (_
 	}
 	return nil, msg, nil
 }
+
+func h_status(w http.ResponseWriter, r *http.Request) (_ control_api.TrayStatus, msg string, _ error) {
+	return app.TrayStatus(), "", nil
+}
+
+func h_presets(w http.ResponseWriter, r *http.Request) (_ []control_api.PresetStatus, msg string, _ error) {
+	return app.PresetStatuses(), "", nil
+}
+
+func h_presetSpecific(w http.ResponseWriter, r *http.Request) (_ *control_api.PresetStatus, msg string, _ error) {
+	presetName := chi.URLParam(r, "preset_name")
+	status, err := app.PresetStatus(presetName)
+	if err != nil {
+		return nil, "", fmt.Errorf("app.PresetStatus: %v", err)
+	}
+	return &status, "", nil
+}
+
+func h_changeLayer[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
+	return sendClientMessage[R](r, tcp_client.ClientMessage{
+		ChangeLayer: &tcp_client.ChangeLayer{NewLayer: chi.URLParam(r, "layer_name")},
+	})
+}
+
+func h_actOnFakeKey[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
+	action, err := tcp_client.ParseFakeKeyAction(chi.URLParam(r, "action"))
+	if err != nil {
+		return nil, "", err
+	}
+	return sendClientMessage[R](r, tcp_client.ClientMessage{
+		ActOnFakeKey: &tcp_client.ActOnFakeKey{
+			Name:   chi.URLParam(r, "key_name"),
+			Action: action,
+		},
+	})
+}
+
+func h_setMouse[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
+	x, err := strconv.ParseUint(chi.URLParam(r, "x"), 10, 16)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid x coordinate: %v", err)
+	}
+	y, err := strconv.ParseUint(chi.URLParam(r, "y"), 10, 16)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid y coordinate: %v", err)
+	}
+	return sendClientMessage[R](r, tcp_client.ClientMessage{
+		SetMouse: &tcp_client.SetMouse{X: uint16(x), Y: uint16(y)},
+	})
+}
+
+func h_reload[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
+	return sendClientMessage[R](r, tcp_client.ClientMessage{Reload: &struct{}{}})
+}
+
+func h_reloadNext[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
+	return sendClientMessage[R](r, tcp_client.ClientMessage{ReloadNext: &struct{}{}})
+}
+
+func h_reloadPrev[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
+	return sendClientMessage[R](r, tcp_client.ClientMessage{ReloadPrev: &struct{}{}})
+}
+
+// Forwards a raw kanata TCP message from request body.
+func h_send[R *struct{}](w http.ResponseWriter, r *http.Request) (_ R, msg string, _ error) {
+	var clientMsg tcp_client.ClientMessage
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&clientMsg)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse request body: %v", err)
+	}
+	return sendClientMessage[R](r, clientMsg)
+}
+
+func sendClientMessage[R *struct{}](r *http.Request, clientMsg tcp_client.ClientMessage) (_ R, msg string, _ error) {
+	presetName := chi.URLParam(r, "preset_name")
+	err := app.SendClientMessage(presetName, clientMsg)
+	if err != nil {
+		return nil, "", fmt.Errorf("app.SendClientMessage: %v", err)
+	}
+	return nil, "", nil
+}
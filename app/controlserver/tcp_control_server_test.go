@@ -0,0 +1,215 @@
+package controlserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+	"github.com/rszyma/kanata-tray/runner/tcp_client"
+)
+
+type fakeApp struct {
+	presets []control_api.PresetStatus
+	events  chan control_api.Event
+	// Messages passed to SendClientMessage, by preset name.
+	sent    map[string][]tcp_client.ClientMessage
+	sendErr error
+}
+
+func newFakeApp(presets ...control_api.PresetStatus) *fakeApp {
+	return &fakeApp{
+		presets: presets,
+		events:  make(chan control_api.Event),
+		sent:    make(map[string][]tcp_client.ClientMessage),
+	}
+}
+
+func (f *fakeApp) StopPreset(presetName string) error             { return nil }
+func (f *fakeApp) StopAllPresets() error                          { return nil }
+func (f *fakeApp) StartPreset(presetName string) error            { return nil }
+func (f *fakeApp) StartAllDefaultPresets() error                  { return nil }
+func (f *fakeApp) TogglePreset(presetName string) (string, error) { return "", nil }
+func (f *fakeApp) ToggleAllDefaultPresets() (string, error)       { return "", nil }
+
+func (f *fakeApp) TrayStatus() control_api.TrayStatus {
+	return control_api.TrayStatus{Presets: f.presets}
+}
+
+func (f *fakeApp) PresetStatuses() []control_api.PresetStatus {
+	return f.presets
+}
+
+func (f *fakeApp) PresetStatus(presetName string) (control_api.PresetStatus, error) {
+	for _, status := range f.presets {
+		if status.Name == presetName {
+			return status, nil
+		}
+	}
+	return control_api.PresetStatus{}, fmt.Errorf("preset with the specified name doesn't exist")
+}
+
+func (f *fakeApp) SubscribeEvents() (<-chan control_api.Event, func()) {
+	return f.events, func() {}
+}
+
+func (f *fakeApp) SendClientMessage(presetName string, msg tcp_client.ClientMessage) error {
+	if f.sendErr != nil {
+		return f.sendErr
+	}
+	f.sent[presetName] = append(f.sent[presetName], msg)
+	return nil
+}
+
+// Sends a request to a router using `fake` as the app and decodes the response.
+func doRequest[T any](t *testing.T, fake *fakeApp, opts ServerOpts, req *http.Request) (int, control_api.GenericResponse[T]) {
+	t.Helper()
+	app = fake
+	rec := httptest.NewRecorder()
+	newRouter(opts).ServeHTTP(rec, req)
+	var resp control_api.GenericResponse[T]
+	body, _ := io.ReadAll(rec.Body)
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("failed to decode response '%s': %v", body, err)
+	}
+	return rec.Code, resp
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	fake := newFakeApp(
+		control_api.PresetStatus{Name: "main", Status: "running", CurrentLayer: "base"},
+		control_api.PresetStatus{Name: "other", Status: "idle"},
+	)
+
+	code, status := doRequest[control_api.TrayStatus](t, fake, ServerOpts{}, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if code != http.StatusOK || !status.IsSuccess || len(status.Data.Presets) != 2 {
+		t.Errorf("GET /status = %d %+v", code, status)
+	}
+
+	code, presets := doRequest[[]control_api.PresetStatus](t, fake, ServerOpts{}, httptest.NewRequest(http.MethodGet, "/presets", nil))
+	if code != http.StatusOK || len(presets.Data) != 2 || presets.Data[1].Name != "other" {
+		t.Errorf("GET /presets = %d %+v", code, presets)
+	}
+
+	code, preset := doRequest[control_api.PresetStatus](t, fake, ServerOpts{}, httptest.NewRequest(http.MethodGet, "/presets/main", nil))
+	if code != http.StatusOK || preset.Data.CurrentLayer != "base" {
+		t.Errorf("GET /presets/main = %d %+v", code, preset)
+	}
+
+	code, missing := doRequest[*struct{}](t, fake, ServerOpts{}, httptest.NewRequest(http.MethodGet, "/presets/missing", nil))
+	if code != http.StatusBadRequest || missing.IsSuccess {
+		t.Errorf("GET /presets/missing = %d %+v", code, missing)
+	}
+
+	code, _ = doRequest[*struct{}](t, fake, ServerOpts{}, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /status = %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestSendRoutes(t *testing.T) {
+	fake := newFakeApp(control_api.PresetStatus{Name: "main", Status: "running"})
+	tests := []struct {
+		path string
+		body string
+		want tcp_client.ClientMessage
+	}{
+		{"/presets/main/layer/nav", "", tcp_client.ClientMessage{ChangeLayer: &tcp_client.ChangeLayer{NewLayer: "nav"}}},
+		{"/presets/main/fake_key/vk1/tap", "", tcp_client.ClientMessage{ActOnFakeKey: &tcp_client.ActOnFakeKey{Name: "vk1", Action: tcp_client.FakeKeyTap}}},
+		{"/presets/main/mouse/100/200", "", tcp_client.ClientMessage{SetMouse: &tcp_client.SetMouse{X: 100, Y: 200}}},
+		{"/presets/main/reload", "", tcp_client.ClientMessage{Reload: &struct{}{}}},
+		{"/presets/main/reload_next", "", tcp_client.ClientMessage{ReloadNext: &struct{}{}}},
+		{"/presets/main/reload_prev", "", tcp_client.ClientMessage{ReloadPrev: &struct{}{}}},
+		{"/presets/main/send", `{"ReloadNum":{"index":1}}`, tcp_client.ClientMessage{ReloadNum: &tcp_client.ReloadNum{Index: 1}}},
+	}
+	for _, tt := range tests {
+		fake.sent = make(map[string][]tcp_client.ClientMessage)
+		code, resp := doRequest[*struct{}](t, fake, ServerOpts{}, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		if code != http.StatusOK || !resp.IsSuccess {
+			t.Errorf("POST %s = %d %+v", tt.path, code, resp)
+			continue
+		}
+		sent := fake.sent["main"]
+		if len(sent) != 1 || string(sent[0].Bytes()) != string(tt.want.Bytes()) {
+			t.Errorf("POST %s sent %v, want %s", tt.path, sent, tt.want.Bytes())
+		}
+	}
+
+	invalid := []struct {
+		path string
+		body string
+	}{
+		{"/presets/main/fake_key/vk1/smash", ""},
+		{"/presets/main/mouse/-1/200", ""},
+		{"/presets/main/mouse/100/70000", ""},
+		{"/presets/main/send", `{"Unknown":{}}`},
+		{"/presets/main/send", `not json`},
+	}
+	for _, tt := range invalid {
+		fake.sent = make(map[string][]tcp_client.ClientMessage)
+		code, resp := doRequest[*struct{}](t, fake, ServerOpts{}, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		if code != http.StatusBadRequest || resp.IsSuccess {
+			t.Errorf("POST %s %s = %d %+v, want an error", tt.path, tt.body, code, resp)
+		}
+		if len(fake.sent) != 0 {
+			t.Errorf("POST %s %s sent %v, want nothing sent", tt.path, tt.body, fake.sent)
+		}
+	}
+
+	// Errors reported by kanata are returned to the client.
+	fake.sendErr = fmt.Errorf("kanata rejected the message: layer does not exist")
+	code, resp := doRequest[*struct{}](t, fake, ServerOpts{}, httptest.NewRequest(http.MethodPost, "/presets/main/layer/missing", nil))
+	if code != http.StatusBadRequest || !strings.Contains(resp.Message, "layer does not exist") {
+		t.Errorf("POST /presets/main/layer/missing = %d %+v, want kanata error", code, resp)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions are not supported on windows")
+	}
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+
+	listener, err := listenUnix(socketPath, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket permissions = %o, want 600", perm)
+	}
+
+	// Socket of a live server is not taken over.
+	if _, err := listenUnix(socketPath, 0o600); err == nil {
+		t.Error("listenUnix succeeded on a socket that is in use")
+	}
+	listener.Close()
+
+	// Socket left over by a server that didn't exit cleanly is replaced.
+	// Closing the listener removes the socket, so a leftover is simulated.
+	if err := os.WriteFile(socketPath, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	listener, err = listenUnix(socketPath, 0o660)
+	if err != nil {
+		t.Fatalf("listenUnix didn't replace stale socket: %v", err)
+	}
+	defer listener.Close()
+	info, err = os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o660 {
+		t.Errorf("socket permissions = %o, want 660", perm)
+	}
+}
@@ -2,19 +2,15 @@ package controlserver
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync/atomic"
 
-	"github.com/labstack/gommon/log"
+	"github.com/rszyma/kanata-tray/app/control_api"
+	"github.com/rszyma/kanata-tray/logging"
 )
 
-type GenericResponse[T any] struct {
-	IsSuccess bool
-	Message   string `json:",omitempty"`
-	Data      T      `json:",omitempty"`
-}
-
 func JsonMustMarshalIndent(data any) string {
 	return string(JsonMustMarshalIndentB(data))
 }
@@ -29,6 +25,20 @@ func JsonMustMarshalIndentB(data any) []byte {
 	return j
 }
 
+// An error with associated HTTP status code.
+type statusError struct {
+	code int
+	err  error
+}
+
+func (e statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e statusError) Unwrap() error {
+	return e.err
+}
+
 var globalReqCount atomic.Int32
 
 func WrapGenericResp[R any](
@@ -36,20 +46,16 @@ func WrapGenericResp[R any](
 ) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		reqCount := globalReqCount.Add(1)
-		log.Infof("[req=%d] request received: %s", reqCount, r.URL.Path)
+		logging.Infof(nil, "[req=%d] request received: %s %s", reqCount, r.Method, r.URL.Path)
 		data, msg, err := fn(w, r)
 		if err != nil {
-			log.Errorf("[req=%d] request handling failed: %v", reqCount, err)
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, "%s", JsonMustMarshalIndent(GenericResponse[R]{
-				IsSuccess: false,
-				Message:   err.Error(),
-			}))
+			logging.Errorf(nil, "[req=%d] request handling failed: %v", reqCount, err)
+			writeErrorResponse(w, r, err)
 		} else {
 			if msg == "" {
 				msg = "OK"
 			}
-			fmt.Fprintf(w, "%s", JsonMustMarshalIndent(GenericResponse[R]{
+			fmt.Fprintf(w, "%s", JsonMustMarshalIndent(control_api.GenericResponse[R]{
 				IsSuccess: true,
 				Message:   msg,
 				Data:      data,
@@ -57,3 +63,21 @@ func WrapGenericResp[R any](
 		}
 	}
 }
+
+// Writes a failed GenericResponse. Status code is taken from `err` if it's
+// a statusError, otherwise 400 is used.
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusBadRequest
+	var statusErr statusError
+	if errors.As(err, &statusErr) {
+		code = statusErr.code
+	}
+	if code == http.StatusUnauthorized || code == http.StatusForbidden {
+		logging.Warnf(nil, "request rejected (%s %s): %v", r.Method, r.URL.Path, err)
+	}
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "%s", JsonMustMarshalIndent(control_api.GenericResponse[*struct{}]{
+		IsSuccess: false,
+		Message:   err.Error(),
+	}))
+}
@@ -1,51 +1,125 @@
 package app
 
 import (
-	"os"
+	"image/color"
 	"path/filepath"
 
-	"github.com/labstack/gommon/log"
-
 	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/icon_render"
+	"github.com/rszyma/kanata-tray/logging"
+	"github.com/rszyma/kanata-tray/status_icons"
 )
 
 type LayerIcons struct {
 	presetIcons  map[string]*LayerIconsForPreset
 	defaultIcons LayerIconsForPreset
+	// Icons with layer badges already drawn, by preset and layer name.
+	renderedBadges map[presetLayer][]byte
 }
 
 type LayerIconsForPreset struct {
 	layerIcons   map[string][]byte
 	wildcardIcon []byte // can be nil
+	badge        config.LayerBadgeMode
+	badgeColors  map[string]color.RGBA
+}
+
+type presetLayer struct {
+	presetName string
+	layerName  string
 }
 
 // Order of resolution:
 // preset -> global -> preset_wildcard -> global_wildcard -> default
 //
+// If layer badges are enabled for the preset, a badge with layer initials
+// is drawn over the resolved icon (or over the default icon).
+//
 // Returns nil if resolution yields no icon. Caller should then use global default icon.
 func (c LayerIcons) IconForLayerName(presetName string, layerName string) []byte {
+	icon := c.mappedIcon(presetName, layerName)
+	preset, ok := c.presetIcons[presetName]
+	if !ok {
+		return icon
+	}
+	switch preset.badge {
+	case config.LayerBadgeAll:
+	case config.LayerBadgeUnmapped:
+		if icon != nil {
+			return icon
+		}
+	default:
+		return icon
+	}
+	key := presetLayer{presetName, layerName}
+	if rendered, ok := c.renderedBadges[key]; ok {
+		return rendered
+	}
+	base := icon
+	if base == nil {
+		base = status_icons.Default
+	}
+	rendered, err := c.renderBadge(preset, base, layerName)
+	if err != nil {
+		logging.Warnf(logging.Fields{"preset": presetName, "layer": layerName}, "Failed to draw badge of layer '%s': %v", layerName, err)
+		return icon
+	}
+	c.renderedBadges[key] = rendered
+	return rendered
+}
+
+func (c LayerIcons) renderBadge(preset *LayerIconsForPreset, base []byte, layerName string) ([]byte, error) {
+	img, err := icon_render.Decode(base)
+	if err != nil {
+		return nil, err
+	}
+	text := icon_render.Initials(layerName)
+	if text == "" {
+		text = "?"
+	}
+	return icon_render.Encode(icon_render.RenderBadge(img, text, c.badgeColor(preset, layerName)))
+}
+
+// Order of resolution is the same as for icons:
+// preset -> global -> preset_wildcard -> global_wildcard -> generated from layer name
+func (c LayerIcons) badgeColor(preset *LayerIconsForPreset, layerName string) color.RGBA {
+	for _, colors := range []map[string]color.RGBA{preset.badgeColors, c.defaultIcons.badgeColors} {
+		if badgeColor, ok := colors[layerName]; ok {
+			return badgeColor
+		}
+	}
+	for _, colors := range []map[string]color.RGBA{preset.badgeColors, c.defaultIcons.badgeColors} {
+		if badgeColor, ok := colors["*"]; ok {
+			return badgeColor
+		}
+	}
+	return icon_render.DefaultBadgeColor(layerName)
+}
+
+// Resolves icon mapped to a layer in config. Returns nil if there's none.
+func (c LayerIcons) mappedIcon(presetName string, layerName string) []byte {
 	// preset
 	preset, ok := c.presetIcons[presetName]
 	if ok {
 		if layerIcon, ok := preset.layerIcons[layerName]; ok {
-			log.Infof("Setting icon: preset:%s, layer:%s", presetName, layerName)
+			logging.Infof(logging.Fields{"preset": presetName, "layer": layerName}, "Setting icon: preset:%s, layer:%s", presetName, layerName)
 			return layerIcon
 		}
 	}
 	// global
 	layerIcon, ok := c.defaultIcons.layerIcons[layerName]
 	if ok {
-		log.Infof("Setting icon: preset:*, layer:%s", layerName)
+		logging.Infof(logging.Fields{"preset": presetName, "layer": layerName}, "Setting icon: preset:*, layer:%s", layerName)
 		return layerIcon
 	}
 	// preset_wildcard
 	if preset != nil && preset.wildcardIcon != nil {
-		log.Infof("Setting icon: preset:%s, layer:*", presetName)
+		logging.Infof(logging.Fields{"preset": presetName, "layer": layerName}, "Setting icon: preset:%s, layer:*", presetName)
 		return preset.wildcardIcon
 	}
 	// global_wildcard
 	if c.defaultIcons.wildcardIcon != nil {
-		log.Infof("Setting icon: preset:*, layer:*")
+		logging.Infof(logging.Fields{"preset": presetName, "layer": layerName}, "Setting icon: preset:*, layer:*")
 		return c.defaultIcons.wildcardIcon
 	}
 	// default
@@ -75,12 +149,14 @@ func ResolveIcons(configFolder string, cfg *config.Config) LayerIcons {
 		defaultIcons: LayerIconsForPreset{
 			layerIcons:   make(map[string][]byte),
 			wildcardIcon: nil,
+			badgeColors:  cfg.PresetDefaults.LayerBadgeColors,
 		},
+		renderedBadges: make(map[presetLayer][]byte),
 	}
 	for layerName, unvalidatedIconPath := range cfg.PresetDefaults.LayerIcons {
 		data, err := readIconInFolder(unvalidatedIconPath, customIconsFolder)
 		if err != nil {
-			log.Warnf("defaults - custom icon file can't be read: %v", err)
+			logging.Warnf(logging.Fields{"layer": layerName}, "defaults - custom icon file can't be read: %v", err)
 		} else if layerName == "*" {
 			icons.defaultIcons.wildcardIcon = data
 		} else {
@@ -96,13 +172,15 @@ func ResolveIcons(configFolder string, cfg *config.Config) LayerIcons {
 			presetIcons = &LayerIconsForPreset{
 				layerIcons:   make(map[string][]byte),
 				wildcardIcon: nil,
+				badge:        preset.LayerBadge,
+				badgeColors:  preset.LayerBadgeColors,
 			}
 			icons.presetIcons[presetName] = presetIcons
 		}
 		for layerName, unvalidatedIconPath := range preset.LayerIcons {
 			data, err := readIconInFolder(unvalidatedIconPath, customIconsFolder)
 			if err != nil {
-				log.Warnf("Preset '%s' - custom icon file can't be read: %v", presetName, err)
+				logging.Warnf(logging.Fields{"preset": presetName, "layer": layerName}, "Custom icon file can't be read: %v", err)
 			} else if layerName == "*" {
 				presetIcons.wildcardIcon = data
 			} else {
@@ -113,16 +191,15 @@ func ResolveIcons(configFolder string, cfg *config.Config) LayerIcons {
 	return icons
 }
 
-func readIconInFolder(filePath string, folder string) ([]byte, error) {
-	var path string
+// Relative icon paths are relative to `folder`.
+func iconPathInFolder(filePath string, folder string) string {
 	if filepath.IsAbs(filePath) {
-		path = filePath
-	} else {
-		path = filepath.Join(folder, filePath)
-	}
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+		return filePath
 	}
-	return content, nil
+	return filepath.Join(folder, filePath)
+}
+
+// Reads an icon and converts it to the format expected by systray.
+func readIconInFolder(filePath string, folder string) ([]byte, error) {
+	return icon_render.LoadFile(iconPathInFolder(filePath, folder))
 }
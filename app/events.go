@@ -0,0 +1,45 @@
+package app
+
+import (
+	"sync"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+)
+
+// Subscriber channels are buffered. If a subscriber doesn't keep up,
+// further events are dropped for it, instead of blocking the publisher.
+const eventSubscriberBufferSize = 64
+
+type eventBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan control_api.Event]struct{}
+}
+
+func newEventBroadcaster() *eventBroadcaster {
+	return &eventBroadcaster{
+		subscribers: make(map[chan control_api.Event]struct{}),
+	}
+}
+
+func (b *eventBroadcaster) publish(event control_api.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (b *eventBroadcaster) subscribe() (events <-chan control_api.Event, unsubscribe func()) {
+	ch := make(chan control_api.Event, eventSubscriberBufferSize)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
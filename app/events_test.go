@@ -0,0 +1,43 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+)
+
+func TestEventBroadcaster(t *testing.T) {
+	b := newEventBroadcaster()
+	events1, unsubscribe1 := b.subscribe()
+	events2, unsubscribe2 := b.subscribe()
+	defer unsubscribe2()
+
+	b.publish(control_api.Event{Type: control_api.EventLayerChange, Layer: "nav"})
+	for _, events := range []<-chan control_api.Event{events1, events2} {
+		select {
+		case event := <-events:
+			if event.Layer != "nav" {
+				t.Errorf("received %+v, want layer change to 'nav'", event)
+			}
+		default:
+			t.Error("event was not delivered to a subscriber")
+		}
+	}
+
+	unsubscribe1()
+	b.publish(control_api.Event{Type: control_api.EventLayerChange, Layer: "base"})
+	select {
+	case event := <-events1:
+		t.Errorf("unsubscribed subscriber received %+v", event)
+	default:
+	}
+	<-events2
+
+	// A subscriber that doesn't keep up doesn't block publishing.
+	for i := 0; i < eventSubscriberBufferSize+1; i++ {
+		b.publish(control_api.Event{Type: control_api.EventConfigFileReload})
+	}
+	if len(events2) != eventSubscriberBufferSize {
+		t.Errorf("subscriber has %d buffered events, want %d", len(events2), eventSubscriberBufferSize)
+	}
+}
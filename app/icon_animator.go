@@ -0,0 +1,156 @@
+package app
+
+import (
+	"time"
+
+	"github.com/rszyma/kanata-tray/icon_render"
+	"github.com/rszyma/kanata-tray/logging"
+	"github.com/rszyma/kanata-tray/status_icons"
+)
+
+// Options of tray icon animations.
+type IconAnimationOptions struct {
+	// Spinner while a preset is starting and blinking icon after a crash.
+	StatusAnimations bool
+	// Number of times the icon blinks when kanata switches layer. 0 disables blinking.
+	LayerChangeFlashes int
+}
+
+// A sequence of icons. Animation with a single frame is a static icon.
+type iconAnimation struct {
+	frames        [][]byte
+	frameDuration time.Duration
+	// Number of times the frames are played, after which the first frame
+	// stays shown. 0 means looping forever.
+	repeat int
+}
+
+func staticIcon(icon []byte) iconAnimation {
+	return iconAnimation{frames: [][]byte{icon}}
+}
+
+// Returns an animation alternating between `icon` and its faded version.
+// If the icon can't be decoded, the icon is returned as a static one.
+func blinkingIcon(icon []byte, frameDuration time.Duration, repeat int) iconAnimation {
+	img, err := icon_render.Decode(icon)
+	if err != nil {
+		logging.Warnf(nil, "Can't blink icon: %v", err)
+		return staticIcon(icon)
+	}
+	faded, err := icon_render.Encode(icon_render.Fade(img, 0.2))
+	if err != nil {
+		logging.Warnf(nil, "Can't blink icon: %v", err)
+		return staticIcon(icon)
+	}
+	return iconAnimation{
+		frames:        [][]byte{icon, faded},
+		frameDuration: frameDuration,
+		repeat:        repeat,
+	}
+}
+
+// Spinner drawn over the default icon, shown while a preset is starting.
+func startingAnimation() iconAnimation {
+	const steps = 8
+	img, err := icon_render.Decode(status_icons.Default)
+	if err != nil {
+		logging.Warnf(nil, "Can't render starting animation: %v", err)
+		return staticIcon(status_icons.Default)
+	}
+	anim := iconAnimation{frameDuration: 125 * time.Millisecond}
+	for step := 0; step < steps; step++ {
+		frame, err := icon_render.Encode(icon_render.RenderSpinner(img, step, steps))
+		if err != nil {
+			logging.Warnf(nil, "Can't render starting animation: %v", err)
+			return staticIcon(status_icons.Default)
+		}
+		anim.frames = append(anim.frames, frame)
+	}
+	return anim
+}
+
+// Plays icon animations on its own goroutine, so that the processing loop
+// is never blocked by them.
+type iconAnimator struct {
+	showCh  chan iconAnimation
+	flashCh chan iconAnimation
+	setIcon func([]byte)
+}
+
+func newIconAnimator(setIcon func([]byte)) *iconAnimator {
+	an := &iconAnimator{
+		showCh:  make(chan iconAnimation),
+		flashCh: make(chan iconAnimation),
+		setIcon: setIcon,
+	}
+	go an.run()
+	return an
+}
+
+// Replaces the current icon (or animation).
+func (an *iconAnimator) Show(anim iconAnimation) {
+	an.showCh <- anim
+}
+
+// Plays an animation once on top of the current one, which is resumed afterwards.
+func (an *iconAnimator) Flash(anim iconAnimation) {
+	an.flashCh <- anim
+}
+
+type animationPlayer struct {
+	anim   iconAnimation
+	frame  int
+	played int
+}
+
+func (p *animationPlayer) isDone() bool {
+	return p.anim.repeat != 0 && p.played >= p.anim.repeat
+}
+
+// Moves to the next frame and returns false if the animation has finished.
+func (p *animationPlayer) advance() bool {
+	p.frame++
+	if p.frame >= len(p.anim.frames) {
+		p.frame = 0
+		p.played++
+	}
+	return !p.isDone()
+}
+
+func (an *iconAnimator) run() {
+	var current, flash *animationPlayer
+	var tick <-chan time.Time
+	for {
+		select {
+		case anim := <-an.showCh:
+			current = &animationPlayer{anim: anim}
+		case anim := <-an.flashCh:
+			anim.repeat = 1
+			flash = &animationPlayer{anim: anim}
+		case <-tick:
+			if flash != nil {
+				if !flash.advance() {
+					flash = nil
+				}
+			} else if current != nil {
+				current.advance()
+			}
+		}
+
+		active := current
+		if flash != nil {
+			active = flash
+		}
+		if active == nil || len(active.anim.frames) == 0 {
+			tick = nil
+			continue
+		}
+		an.setIcon(active.anim.frames[active.frame])
+		// Flashes are timed even if they have a single frame. Other
+		// animations stop on their first frame once they are done.
+		tick = nil
+		if active == flash || len(active.anim.frames) > 1 && !active.isDone() {
+			tick = time.After(active.anim.frameDuration)
+		}
+	}
+}
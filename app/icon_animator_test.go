@@ -0,0 +1,94 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+// Starts an animator that reports icons it sets.
+func newTestIconAnimator() (*iconAnimator, <-chan string) {
+	icons := make(chan string, 16)
+	an := newIconAnimator(func(icon []byte) {
+		icons <- string(icon)
+	})
+	return an, icons
+}
+
+func expectIcons(t *testing.T, icons <-chan string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-icons:
+			if got != w {
+				t.Fatalf("icon set to %q, want %q", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("icon was not set, want %q", w)
+		}
+	}
+	select {
+	case got := <-icons:
+		t.Fatalf("unexpected icon %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func frames(icons ...string) [][]byte {
+	var result [][]byte
+	for _, icon := range icons {
+		result = append(result, []byte(icon))
+	}
+	return result
+}
+
+func TestIconAnimatorStatic(t *testing.T) {
+	an, icons := newTestIconAnimator()
+	an.Show(staticIcon([]byte("a")))
+	expectIcons(t, icons, "a")
+}
+
+func TestIconAnimatorRepeat(t *testing.T) {
+	an, icons := newTestIconAnimator()
+	an.Show(iconAnimation{frames: frames("a", "b"), frameDuration: 10 * time.Millisecond, repeat: 2})
+	// The first frame stays shown once the animation is done.
+	expectIcons(t, icons, "a", "b", "a", "b", "a")
+}
+
+func TestIconAnimatorFlash(t *testing.T) {
+	an, icons := newTestIconAnimator()
+	an.Show(staticIcon([]byte("a")))
+	expectIcons(t, icons, "a")
+
+	// Flash is played once, even if it has a single frame,
+	// and the previous icon is shown again afterwards.
+	an.Flash(iconAnimation{frames: frames("x"), frameDuration: 10 * time.Millisecond})
+	expectIcons(t, icons, "x", "a")
+
+	an.Flash(iconAnimation{frames: frames("x", "y"), frameDuration: 10 * time.Millisecond, repeat: 5})
+	expectIcons(t, icons, "x", "y", "a")
+}
+
+func TestIconAnimatorShowDuringFlash(t *testing.T) {
+	an, icons := newTestIconAnimator()
+	an.Show(staticIcon([]byte("a")))
+	expectIcons(t, icons, "a")
+
+	an.Flash(iconAnimation{frames: frames("x"), frameDuration: 200 * time.Millisecond})
+	an.Show(staticIcon([]byte("b")))
+	// Icon changed while flashing is shown once the flash is over.
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case icon := <-icons:
+			if icon == "b" {
+				expectIcons(t, icons)
+				return
+			}
+			if icon != "x" {
+				t.Fatalf("icon set to %q during flash", icon)
+			}
+		case <-timeout:
+			t.Fatal("icon shown during flash was not set")
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"time"
+
+	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/icon_render"
+	"github.com/rszyma/kanata-tray/logging"
+	"github.com/rszyma/kanata-tray/status_icons"
+)
+
+// Updates tray icon to reflect statuses and layers of presets, choosing
+// between running presets according to `iconPolicy`. Icon is only set
+// if it would change. If `blink` is set, a changed icon blinks
+// (as configured with `LayerChangeFlashes`).
+func (a *SystrayApp) refreshIcon(blink bool) {
+	shown := a.shownPresets()
+	keyParts := make([]string, len(shown))
+	for j, i := range shown {
+		keyParts[j] = fmt.Sprintf("%s:%s:%s", a.presets[i].PresetName, a.statuses[i].Name(), a.presetCurrentLayers[i])
+	}
+	key := strings.Join(keyParts, "|")
+	if len(shown) == 0 {
+		key = "paused"
+	}
+	if key == a.shownIconKey {
+		return
+	}
+	a.shownIconKey = key
+
+	var anim iconAnimation
+	switch len(shown) {
+	case 0:
+		anim = staticIcon(status_icons.Pause)
+	case 1:
+		anim = a.presetIconAnimation(shown[0])
+	default:
+		anim = staticIcon(a.compositeIcon(shown))
+	}
+	if blink && a.animationOpts.LayerChangeFlashes > 0 && len(anim.frames) == 1 {
+		anim = blinkingIcon(anim.frames[0], 150*time.Millisecond, a.animationOpts.LayerChangeFlashes)
+	}
+	a.iconAnimator.Show(anim)
+}
+
+// Returns indices of presets whose icons should be shown in tray.
+// Only running (or starting) presets are considered, so that a crashed preset
+// doesn't hide layer of a running one. Crashed presets are shown only when
+// no preset is running. Empty if all presets are idle.
+func (a *SystrayApp) shownPresets() []int {
+	var running, crashed []int
+	for i, status := range a.statuses {
+		switch status {
+		case statusRunning, statusStarting:
+			running = append(running, i)
+		case statusCrashed:
+			crashed = append(crashed, i)
+		case statusIdle:
+		}
+	}
+	active := running
+	if len(active) == 0 {
+		active = crashed
+	}
+	if len(active) <= 1 || a.iconPolicy == config.IconPolicyComposite {
+		return active
+	}
+	// `active` is in config order, so on ties the preset declared first wins.
+	best := active[0]
+	for _, i := range active[1:] {
+		switch a.iconPolicy {
+		case config.IconPolicyPriority:
+			if a.presets[i].Preset.IconPriority > a.presets[best].Preset.IconPriority {
+				best = i
+			}
+		case config.IconPolicyFocus:
+			if a.presetLastActivity[i].After(a.presetLastActivity[best]) {
+				best = i
+			}
+		}
+	}
+	return []int{best}
+}
+
+// Marks a preset as the most recently active one, for `IconPolicyFocus`.
+func (a *SystrayApp) touchPreset(presetIndex int) {
+	a.presetLastActivity[presetIndex] = time.Now()
+}
+
+// Icon (or animation) reflecting status and layer of a single preset.
+func (a *SystrayApp) presetIconAnimation(presetIndex int) iconAnimation {
+	switch a.statuses[presetIndex] {
+	case statusStarting:
+		if a.animationOpts.StatusAnimations {
+			return a.startingAnimation
+		}
+		return staticIcon(status_icons.Default)
+	case statusCrashed:
+		if a.animationOpts.StatusAnimations {
+			return a.crashAnimation
+		}
+		return staticIcon(status_icons.Crash)
+	case statusRunning:
+		layerName := a.presetCurrentLayers[presetIndex]
+		if layerName == "" {
+			return staticIcon(status_icons.Default)
+		}
+		icon := a.layerIcons.IconForLayerName(a.presets[presetIndex].PresetName, layerName)
+		if icon == nil {
+			icon = status_icons.Default
+		}
+		return staticIcon(icon)
+	case statusIdle:
+	}
+	return staticIcon(status_icons.Pause)
+}
+
+// Renders icons of multiple presets side by side. Animated icons are
+// represented by their first frame.
+func (a *SystrayApp) compositeIcon(presetIndices []int) []byte {
+	var images []image.Image
+	for _, i := range presetIndices {
+		icon := a.presetIconAnimation(i).frames[0]
+		img, err := icon_render.Decode(icon)
+		if err != nil {
+			logging.Warnf(a.presetLogFields(i), "Can't render icon of preset '%s' in composite icon: %v", a.presets[i].PresetName, err)
+			continue
+		}
+		images = append(images, img)
+	}
+	if len(images) == 0 {
+		return status_icons.Default
+	}
+	icon, err := icon_render.Encode(icon_render.Composite(images))
+	if err != nil {
+		logging.Warnf(nil, "Can't render composite icon: %v", err)
+		return status_icons.Default
+	}
+	return icon
+}
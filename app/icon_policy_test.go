@@ -0,0 +1,74 @@
+package app
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rszyma/kanata-tray/config"
+)
+
+func newIconPolicyTestApp(policy config.IconPolicy, priorities []int, statuses []KanataStatus) *SystrayApp {
+	a := &SystrayApp{iconPolicy: policy, statuses: statuses}
+	for i, priority := range priorities {
+		a.presets = append(a.presets, PresetMenuEntry{Preset: config.Preset{IconPriority: priority}})
+		a.presetLastActivity = append(a.presetLastActivity, time.Unix(int64(i), 0))
+	}
+	return a
+}
+
+func TestShownPresetsPriority(t *testing.T) {
+	a := newIconPolicyTestApp(config.IconPolicyPriority,
+		[]int{0, 0, 5, 5},
+		[]KanataStatus{statusRunning, statusRunning, statusIdle, statusIdle})
+	// Same priority: the preset declared first wins, regardless of activity.
+	a.presetLastActivity[1] = time.Now()
+	if got := a.shownPresets(); !slices.Equal(got, []int{0}) {
+		t.Errorf("shownPresets() = %v, want [0]", got)
+	}
+
+	a.statuses[2], a.statuses[3] = statusRunning, statusCrashed
+	if got := a.shownPresets(); !slices.Equal(got, []int{2}) {
+		t.Errorf("shownPresets() = %v, want [2]", got)
+	}
+}
+
+func TestShownPresetsCrashed(t *testing.T) {
+	a := newIconPolicyTestApp(config.IconPolicyPriority,
+		[]int{0, 5, 0},
+		[]KanataStatus{statusRunning, statusCrashed, statusIdle})
+	// Crashed preset with higher priority doesn't hide the running one.
+	if got := a.shownPresets(); !slices.Equal(got, []int{0}) {
+		t.Errorf("shownPresets() = %v, want [0]", got)
+	}
+	a.statuses[0] = statusIdle
+	if got := a.shownPresets(); !slices.Equal(got, []int{1}) {
+		t.Errorf("shownPresets() with no running preset = %v, want [1]", got)
+	}
+}
+
+func TestShownPresetsFocus(t *testing.T) {
+	a := newIconPolicyTestApp(config.IconPolicyFocus,
+		[]int{5, 0, 0},
+		[]KanataStatus{statusRunning, statusRunning, statusIdle})
+	if got := a.shownPresets(); !slices.Equal(got, []int{1}) {
+		t.Errorf("shownPresets() = %v, want [1]", got)
+	}
+	a.touchPreset(0)
+	if got := a.shownPresets(); !slices.Equal(got, []int{0}) {
+		t.Errorf("shownPresets() after touchPreset(0) = %v, want [0]", got)
+	}
+}
+
+func TestShownPresetsComposite(t *testing.T) {
+	a := newIconPolicyTestApp(config.IconPolicyComposite,
+		[]int{0, 0, 0},
+		[]KanataStatus{statusRunning, statusIdle, statusStarting})
+	if got := a.shownPresets(); !slices.Equal(got, []int{0, 2}) {
+		t.Errorf("shownPresets() = %v, want [0 2]", got)
+	}
+	a.statuses[0], a.statuses[2] = statusIdle, statusIdle
+	if got := a.shownPresets(); len(got) != 0 {
+		t.Errorf("shownPresets() with no running presets = %v, want none", got)
+	}
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name of the directory in kanata-tray log folder, that contains kanata logs of all presets.
+const KanataLogsDirName = "kanata_logs"
+
+// Kanata log of a single preset. Logs are kept in a per-preset directory:
+//   - kanata.log - log of the current (or the last) run,
+//   - kanata.1.log, kanata.2.log, ... - logs of previous runs, or previous parts
+//     of the current run if it exceeded maximum size (higher number = older),
+//   - kanata.crash-<time>[_<n>].log - logs of runs that crashed. These are rotated
+//     separately, so crash logs are not pushed out by logs of the runs that followed.
+//
+// Recent output of hooks is also written to hooks.log in that directory, when requested.
+//
+// Writes are safe to be called concurrently with other methods.
+type kanataLog struct {
+	mu       sync.Mutex
+	dir      string
+	maxSize  int64
+	maxFiles int
+	file     *os.File // nil after closing
+	filePath string   // path to the current or last closed log file
+	size     int64
+}
+
+const (
+	kanataLogName         = "kanata.log"
+	hookLogName           = "hooks.log"
+	kanataCrashLogPrefix  = "kanata.crash-"
+	kanataCrashTimeFormat = "20060102-150405.000"
+)
+
+// Replaces characters that are not safe in file names. A short hash of
+// the raw name is appended, so that presets with names differing only
+// in replaced characters (or in letter case) don't share a directory.
+func presetLogDirName(presetName string) string {
+	safeName := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, presetName)
+	h := fnv.New32a()
+	h.Write([]byte(presetName))
+	return fmt.Sprintf("%s-%08x", safeName, h.Sum32())
+}
+
+// Writes recent hook output of a preset to a file next to its kanata logs.
+// Returns path to the file.
+func writeHookLog(logsDir string, presetName string, content string) (string, error) {
+	dir := filepath.Join(logsDir, presetLogDirName(presetName))
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("failed to create log directory: %v", err)
+	}
+	path := filepath.Join(dir, hookLogName)
+	err = os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Starts a new kanata log for a preset. Log of the previous run is rotated.
+func openKanataLog(logsDir string, presetName string, maxSize int64, maxFiles int) (*kanataLog, error) {
+	dir := filepath.Join(logsDir, presetLogDirName(presetName))
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	l := &kanataLog{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		filePath: filepath.Join(dir, kanataLogName),
+	}
+	err = l.rotate()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *kanataLog) numberedPath(n int) string {
+	return filepath.Join(l.dir, fmt.Sprintf("kanata.%d.log", n))
+}
+
+// Shifts numbered logs, moves current log to kanata.1.log and opens a new one.
+// An empty current log (e.g. kanata failed to start) is reused instead, so that
+// repeated failed starts don't push out logs of previous runs.
+func (l *kanataLog) rotate() error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	currentPath := filepath.Join(l.dir, kanataLogName)
+	if info, err := os.Stat(currentPath); err == nil && info.Size() > 0 {
+		os.Remove(l.numberedPath(l.maxFiles))
+		for n := l.maxFiles - 1; n >= 1; n-- {
+			os.Rename(l.numberedPath(n), l.numberedPath(n+1))
+		}
+		err := os.Rename(currentPath, l.numberedPath(1))
+		if err != nil {
+			return fmt.Errorf("failed to rotate kanata log: %v", err)
+		}
+	}
+	f, err := os.Create(currentPath)
+	if err != nil {
+		return fmt.Errorf("failed to create kanata log file: %v", err)
+	}
+	l.file = f
+	l.filePath = currentPath
+	l.size = 0
+	return nil
+}
+
+func (l *kanataLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return 0, os.ErrClosed
+	}
+	if l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		err := l.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Returns path to the current log file, or to the crash log if
+// the log was kept with `KeepAsCrashLog`.
+func (l *kanataLog) Name() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.filePath
+}
+
+func (l *kanataLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Closes the log and moves it aside, so that it doesn't get rotated out
+// by logs of the following runs. Oldest crash logs exceeding `maxFiles` are removed.
+func (l *kanataLog) KeepAsCrashLog() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	crashLogPath := l.newCrashLogPath(time.Now())
+	err := os.Rename(l.filePath, crashLogPath)
+	if err != nil {
+		return fmt.Errorf("failed to keep crash log: %v", err)
+	}
+	l.filePath = crashLogPath
+
+	crashLogs, err := filepath.Glob(filepath.Join(l.dir, kanataCrashLogPrefix+"*.log"))
+	if err != nil {
+		return err
+	}
+	// Timestamps in names make lexical order chronological.
+	sort.Strings(crashLogs)
+	for len(crashLogs) > l.maxFiles {
+		os.Remove(crashLogs[0])
+		crashLogs = crashLogs[1:]
+	}
+	return nil
+}
+
+// Returns path for a new crash log, that doesn't overwrite an existing one.
+// A counter is added to the name if there's already a crash log with the same time.
+// '_' sorts after '.', so lexical order of names stays chronological.
+func (l *kanataLog) newCrashLogPath(t time.Time) string {
+	base := kanataCrashLogPrefix + t.Format(kanataCrashTimeFormat)
+	path := filepath.Join(l.dir, base+".log")
+	for n := 1; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(l.dir, fmt.Sprintf("%s_%d.log", base, n))
+	}
+}
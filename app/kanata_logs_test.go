@@ -0,0 +1,122 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPresetLogDirName(t *testing.T) {
+	names := []string{"a b", "a_b", "a/b", "A_b"}
+	seen := map[string]string{}
+	for _, name := range names {
+		dirName := presetLogDirName(name)
+		if strings.ContainsAny(dirName, " /") {
+			t.Errorf("presetLogDirName(%q) = %q, contains unsafe characters", name, dirName)
+		}
+		if other, ok := seen[strings.ToLower(dirName)]; ok {
+			t.Errorf("presetLogDirName(%q) collides with presetLogDirName(%q): %q", name, other, dirName)
+		}
+		seen[strings.ToLower(dirName)] = name
+	}
+	if presetLogDirName("a b") != presetLogDirName("a b") {
+		t.Error("presetLogDirName is not deterministic")
+	}
+}
+
+func TestKanataLogRotate(t *testing.T) {
+	logsDir := t.TempDir()
+	for i := 0; i < 4; i++ {
+		l, err := openKanataLog(logsDir, "p", 1024, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Write([]byte("run"))
+		l.Close()
+	}
+	entries, err := os.ReadDir(filepath.Join(logsDir, presetLogDirName("p")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{"kanata.1.log", "kanata.2.log", "kanata.log"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("log files = %v, want %v", got, want)
+	}
+}
+
+func TestKanataLogEmptyLogIsNotRotated(t *testing.T) {
+	logsDir := t.TempDir()
+	l, err := openKanataLog(logsDir, "p", 1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write([]byte("real run"))
+	l.Close()
+	// Runs that failed before kanata wrote anything.
+	for i := 0; i < 3; i++ {
+		l, err := openKanataLog(logsDir, "p", 1024, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+	}
+	content, err := os.ReadFile(filepath.Join(logsDir, presetLogDirName("p"), "kanata.1.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "real run" {
+		t.Errorf("kanata.1.log = %q, want log of the last real run", content)
+	}
+}
+
+func TestKanataLogKeepAsCrashLog(t *testing.T) {
+	logsDir := t.TempDir()
+	var crashLogs []string
+	// Crashes in quick succession must not overwrite each other's logs.
+	for i := 0; i < 3; i++ {
+		l, err := openKanataLog(logsDir, "p", 1024, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Write([]byte("crash"))
+		err = l.KeepAsCrashLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		crashLogs = append(crashLogs, l.Name())
+	}
+	for i, path := range crashLogs {
+		if !strings.HasPrefix(filepath.Base(path), kanataCrashLogPrefix) {
+			t.Errorf("crash log %q doesn't have crash log prefix", path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("crash log #%d: %v", i, err)
+		}
+		if i > 0 && path <= crashLogs[i-1] {
+			t.Errorf("crash log names are not in chronological order: %q <= %q", path, crashLogs[i-1])
+		}
+	}
+}
+
+func TestKanataLogKeepAsCrashLogLimit(t *testing.T) {
+	logsDir := t.TempDir()
+	for i := 0; i < 4; i++ {
+		l, err := openKanataLog(logsDir, "p", 1024, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.KeepAsCrashLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	crashLogs, _ := filepath.Glob(filepath.Join(logsDir, presetLogDirName("p"), kanataCrashLogPrefix+"*.log"))
+	if len(crashLogs) != 2 {
+		t.Errorf("got %d crash logs, want 2", len(crashLogs))
+	}
+}
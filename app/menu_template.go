@@ -12,31 +12,77 @@ type PresetMenuEntry struct {
 	IsSelectable bool
 	Preset       config.Preset
 	PresetName   string
+	// Problems found by `ValidatePresets`.
+	Diagnostics []Diagnostic
 }
 
 type KanataStatus string
 
 const (
 	statusIdle     KanataStatus = "Kanata Status: Not Running (click to run)"
-	statusStarting KanataStatus = "Kanata Status: Starting..."
+	statusStarting KanataStatus = "Kanata Status: Starting... (click to stop)"
 	statusRunning  KanataStatus = "Kanata Status: Running (click to stop)"
 	statusCrashed  KanataStatus = "Kanata Status: Crashed (click to restart)"
 )
 
-func (m *PresetMenuEntry) Title(status KanataStatus) string {
-	switch status {
+// Short, machine-friendly name of the status.
+func (s KanataStatus) Name() string {
+	switch s {
 	case statusIdle:
-		return "Preset: " + m.PresetName
+		return "idle"
+	case statusStarting:
+		return "starting"
+	case statusRunning:
+		return "running"
+	case statusCrashed:
+		return "crashed"
+	}
+	return "unknown"
+}
+
+// Title of the preset menu item, from `menu_title` template.
+func (m *PresetMenuEntry) Title(template string, status KanataStatus, layerName string) string {
+	return m.expandTemplate(template, status, layerName)
+}
+
+// Substitutes placeholders listed in `config.TemplatePlaceholders`.
+// Layer name is empty if it's unknown.
+func (m *PresetMenuEntry) expandTemplate(template string, status KanataStatus, layerName string) string {
+	if layerName == "" {
+		layerName = "-"
+	}
+	return strings.NewReplacer(
+		"{preset}", m.PresetName,
+		"{layer}", layerName,
+		"{status}", status.Name(),
+		"{marker}", m.marker(status),
+	).Replace(template)
+}
+
+// Prefix of menu title, indicating status of the preset.
+func (m *PresetMenuEntry) marker(status KanataStatus) string {
+	if !m.IsSelectable {
+		return "[INVALID] "
+	}
+	switch status {
 	case statusRunning:
-		return "> Preset: " + m.PresetName
+		return "> "
 	case statusCrashed:
-		return "[ERR] Preset: " + m.PresetName
+		return "[ERR] "
+	case statusIdle, statusStarting:
 	}
-	return "Preset: " + m.PresetName
+	return ""
 }
 
 func (m *PresetMenuEntry) Tooltip() string {
-	return "Switch to preset: " + m.PresetName
+	tooltip := "Switch to preset: " + m.PresetName
+	if !m.IsSelectable {
+		tooltip = "Preset can't be run: " + m.PresetName
+	}
+	for _, d := range m.Diagnostics {
+		tooltip += "\n" + d.String()
+	}
+	return tooltip
 }
 
 func MenuTemplateFromConfig(cfg config.Config) ([]PresetMenuEntry, error) {
@@ -46,20 +92,9 @@ func MenuTemplateFromConfig(cfg config.Config) ([]PresetMenuEntry, error) {
 		presetName := m.Key
 		preset := m.Value
 
-		// TODO: resolve path here? and put it in value?
-		//
-		// Resolve later could be better, since cfg can be also an empty value.
-		// expandedPath, err := resolveFilePath(*p.CfgPath)
-		//
-		// We could also validate path ONLY if it's non empty.
-		// Because if it's empty, kanata can still search default locations.
-		//
-		// But what about kanata executable path? should it be resolved later too?
-		// Probably not. If we can catch an error here it would be good, because
-		// we would be able to display it as an error in menu, whereas checking
-		// when trying to run would only display an error in console. But it's very
-		// likely that users want to hide console, that's why they use kanata-tray
-		// in the first place.
+		// Paths are only expanded here. They are checked separately
+		// by ValidatePresets, so that problems can be displayed in menu,
+		// instead of only being logged when trying to run the preset.
 
 		var err error
 		preset.KanataConfig, err = expandHomeDir(preset.KanataConfig)
@@ -76,6 +111,14 @@ func MenuTemplateFromConfig(cfg config.Config) ([]PresetMenuEntry, error) {
 				return nil, err
 			}
 		}
+		for _, hooks := range hooksByType(preset.Hooks) {
+			for i := range hooks.hooks {
+				hooks.hooks[i].Cwd, err = expandHomeDir(hooks.hooks[i].Cwd)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
 
 		entry := PresetMenuEntry{
 			IsSelectable: true,
@@ -89,6 +132,25 @@ func MenuTemplateFromConfig(cfg config.Config) ([]PresetMenuEntry, error) {
 	return presets, nil
 }
 
+type hooksOfType struct {
+	hookType string
+	hooks    []config.Hook
+}
+
+// Returns hooks of all types, in the order they are run in.
+func hooksByType(hooks config.Hooks) []hooksOfType {
+	return []hooksOfType{
+		{"pre-start", hooks.PreStart},
+		{"post-start", hooks.PostStart},
+		{"post-start-async", hooks.PostStartAsync},
+		{"on-layer-change", hooks.OnLayerChange},
+		{"on-config-reload", hooks.OnConfigReload},
+		{"pre-stop", hooks.PreStop},
+		{"on-crash", hooks.OnCrash},
+		{"post-stop", hooks.PostStop},
+	}
+}
+
 func expandHomeDir(path string) (string, error) {
 	if strings.Contains(path, "~") {
 		dirname, err := os.UserHomeDir()
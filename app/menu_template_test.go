@@ -0,0 +1,30 @@
+package app
+
+import "testing"
+
+func TestExpandTemplate(t *testing.T) {
+	entry := PresetMenuEntry{PresetName: "main", IsSelectable: true}
+	tests := []struct {
+		template  string
+		status    KanataStatus
+		layerName string
+		want      string
+	}{
+		{"{preset}: {layer} ({status})", statusRunning, "nav", "main: nav (running)"},
+		{"{preset}: {layer} ({status})", statusIdle, "", "main: - (idle)"},
+		{"{marker}Preset: {preset}", statusRunning, "", "> Preset: main"},
+		{"{marker}Preset: {preset}", statusCrashed, "", "[ERR] Preset: main"},
+		{"{marker}Preset: {preset}", statusStarting, "", "Preset: main"},
+		{"no placeholders", statusRunning, "nav", "no placeholders"},
+	}
+	for _, tt := range tests {
+		if got := entry.expandTemplate(tt.template, tt.status, tt.layerName); got != tt.want {
+			t.Errorf("expandTemplate(%q, %s, %q) = %q, want %q", tt.template, tt.status.Name(), tt.layerName, got, tt.want)
+		}
+	}
+
+	invalid := PresetMenuEntry{PresetName: "broken"}
+	if got, want := invalid.expandTemplate("{marker}{preset}", statusIdle, ""), "[INVALID] broken"; got != want {
+		t.Errorf("expandTemplate of invalid preset = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+// Package notifications sends desktop notifications, e.g. when a preset crashes.
+// On Linux they are sent over D-Bus to `org.freedesktop.Notifications` service
+// (implemented by most desktop environments and notification daemons).
+// On other platforms sending notifications is a no-op.
+package notifications
+
+import (
+	"sync"
+
+	"github.com/rszyma/kanata-tray/logging"
+)
+
+const appName = "kanata-tray"
+
+// Notifications not sent yet, above which new ones are dropped.
+const queueSize = 16
+
+type Urgency byte
+
+const (
+	UrgencyLow      Urgency = 0
+	UrgencyNormal   Urgency = 1
+	UrgencyCritical Urgency = 2
+)
+
+type Notification struct {
+	// A notification replaces the previously sent one with the same key,
+	// if it's still displayed. Empty key means that notification is always
+	// displayed separately.
+	Key     string
+	Summary string
+	Body    string
+	Urgency Urgency
+}
+
+// Delivers a notification to the desktop, implemented by platform-specific
+// `backend`. Returns ID of the sent notification.
+type sender interface {
+	notify(notification Notification, replacesID uint32) (uint32, error)
+}
+
+// Sends notifications in background, one at a time, so that callers
+// are not blocked by a slow or missing notification service.
+type Notifier struct {
+	queue  chan Notification
+	sender sender
+
+	mu sync.Mutex
+	// Maps notification keys to IDs of the last notification sent with that key.
+	sentIDs map[string]uint32
+}
+
+func NewNotifier() *Notifier {
+	return newNotifier(&backend{})
+}
+
+func newNotifier(sender sender) *Notifier {
+	n := &Notifier{
+		queue:   make(chan Notification, queueSize),
+		sender:  sender,
+		sentIDs: make(map[string]uint32),
+	}
+	go func() {
+		for notification := range n.queue {
+			n.send(notification)
+		}
+	}()
+	return n
+}
+
+// Queues a notification. Doesn't block.
+func (n *Notifier) Send(notification Notification) {
+	select {
+	case n.queue <- notification:
+	default:
+		logging.Warnf(nil, "Too many pending notifications, dropping notification '%s'", notification.Summary)
+	}
+}
+
+func (n *Notifier) send(notification Notification) {
+	n.mu.Lock()
+	replacesID := n.sentIDs[notification.Key]
+	n.mu.Unlock()
+
+	id, err := n.sender.notify(notification, replacesID)
+	if err != nil {
+		logging.Warnf(nil, "Failed to send desktop notification: %v", err)
+		return
+	}
+
+	if notification.Key != "" {
+		n.mu.Lock()
+		n.sentIDs[notification.Key] = id
+		n.mu.Unlock()
+	}
+}
@@ -0,0 +1,52 @@
+package notifications
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	notificationsService   = "org.freedesktop.Notifications"
+	notificationsPath      = "/org/freedesktop/Notifications"
+	notificationsNotifyMsg = notificationsService + ".Notify"
+)
+
+// Sends notifications over D-Bus session bus.
+// Only used from the `Notifier` goroutine.
+type backend struct {
+	conn *dbus.Conn // nil until the first notification, or after connection failure
+}
+
+// Returns ID of the sent notification.
+func (b *backend) notify(notification Notification, replacesID uint32) (uint32, error) {
+	if b.conn == nil {
+		conn, err := dbus.ConnectSessionBus()
+		if err != nil {
+			return 0, fmt.Errorf("failed to connect to D-Bus session bus: %v", err)
+		}
+		b.conn = conn
+	}
+	hints := map[string]dbus.Variant{
+		"urgency": dbus.MakeVariant(byte(notification.Urgency)),
+	}
+	var id uint32
+	err := b.conn.Object(notificationsService, notificationsPath).Call(
+		notificationsNotifyMsg, 0,
+		appName,
+		replacesID,
+		"", // app icon
+		notification.Summary,
+		notification.Body,
+		[]string{}, // actions
+		hints,
+		int32(-1), // expire timeout, -1 means default of the notification server
+	).Store(&id)
+	if err != nil {
+		// Connection may be broken, reconnect on next notification.
+		b.conn.Close()
+		b.conn = nil
+		return 0, err
+	}
+	return id, nil
+}
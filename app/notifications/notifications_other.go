@@ -0,0 +1,10 @@
+//go:build !linux
+
+package notifications
+
+// Desktop notifications are not implemented on this platform.
+type backend struct{}
+
+func (b *backend) notify(notification Notification, replacesID uint32) (uint32, error) {
+	return 0, nil
+}
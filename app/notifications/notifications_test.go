@@ -0,0 +1,118 @@
+package notifications
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type sentNotification struct {
+	notification Notification
+	replacesID   uint32
+}
+
+type fakeSender struct {
+	sent   chan sentNotification
+	nextID uint32
+	// Number of notifications that fail to be sent, before sending succeeds.
+	failures int
+	// If not nil, sending signals `entered` and blocks until `block` is closed.
+	block   chan struct{}
+	entered chan struct{}
+}
+
+func (f *fakeSender) notify(notification Notification, replacesID uint32) (uint32, error) {
+	if f.block != nil {
+		f.entered <- struct{}{}
+		<-f.block
+	}
+	defer func() { f.sent <- sentNotification{notification, replacesID} }()
+	if f.failures > 0 {
+		f.failures--
+		return 0, errors.New("no notification service")
+	}
+	f.nextID++
+	return f.nextID, nil
+}
+
+func receive(t *testing.T, sent <-chan sentNotification) sentNotification {
+	t.Helper()
+	select {
+	case s := <-sent:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not sent")
+		return sentNotification{}
+	}
+}
+
+func TestNotifierReplacesByKey(t *testing.T) {
+	sender := &fakeSender{sent: make(chan sentNotification, queueSize)}
+	n := newNotifier(sender)
+
+	n.Send(Notification{Key: "state:main", Summary: "crashed"})
+	if s := receive(t, sender.sent); s.replacesID != 0 {
+		t.Errorf("first notification replaces %d, want 0", s.replacesID)
+	}
+	n.Send(Notification{Key: "state:other", Summary: "crashed"})
+	if s := receive(t, sender.sent); s.replacesID != 0 {
+		t.Errorf("notification with another key replaces %d, want 0", s.replacesID)
+	}
+	n.Send(Notification{Key: "state:main", Summary: "crashed again"})
+	if s := receive(t, sender.sent); s.replacesID != 1 {
+		t.Errorf("notification with the same key replaces %d, want 1", s.replacesID)
+	}
+	// Notifications without a key never replace each other.
+	n.Send(Notification{Summary: "a"})
+	n.Send(Notification{Summary: "b"})
+	for i := 0; i < 2; i++ {
+		if s := receive(t, sender.sent); s.replacesID != 0 {
+			t.Errorf("notification without a key replaces %d, want 0", s.replacesID)
+		}
+	}
+}
+
+func TestNotifierFailedSend(t *testing.T) {
+	sender := &fakeSender{sent: make(chan sentNotification, queueSize), failures: 1}
+	n := newNotifier(sender)
+	n.Send(Notification{Key: "state:main"})
+	receive(t, sender.sent)
+
+	n.Send(Notification{Key: "state:main"})
+	if s := receive(t, sender.sent); s.replacesID != 0 {
+		t.Errorf("notification replaces %d, which was never sent", s.replacesID)
+	}
+}
+
+func TestNotifierSendDoesNotBlock(t *testing.T) {
+	sender := &fakeSender{
+		sent:    make(chan sentNotification, queueSize+1),
+		block:   make(chan struct{}),
+		entered: make(chan struct{}, queueSize+1),
+	}
+	n := newNotifier(sender)
+	n.Send(Notification{Summary: "being sent"})
+	<-sender.entered
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < queueSize+5; i++ {
+			n.Send(Notification{Summary: "queued"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send blocked on a slow notification service")
+	}
+	close(sender.block)
+	for i := 0; i < queueSize+1; i++ {
+		receive(t, sender.sent)
+	}
+	select {
+	case <-sender.sent:
+		t.Error("notifications over the queue limit were sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
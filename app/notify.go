@@ -0,0 +1,80 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rszyma/kanata-tray/app/notifications"
+	runner_pkg "github.com/rszyma/kanata-tray/runner"
+)
+
+// Events on which desktop notifications are sent.
+type NotifyOptions struct {
+	Crash         bool
+	RestartGiveUp bool
+	HookFailure   bool
+	LayerChange   bool
+}
+
+// Key of notifications about state of a preset. A newer one replaces the
+// older one, so e.g. repeated crashes don't pile up notifications.
+func presetStateNotificationKey(presetName string) string {
+	return "state:" + presetName
+}
+
+// Notifies about a preset that has exited with an error.
+func (a *SystrayApp) notifyPresetError(presetIndex int, err error) {
+	presetName := a.presets[presetIndex].PresetName
+	var hookErr *runner_pkg.HookError
+	if errors.As(err, &hookErr) {
+		if !a.notifyOpts.HookFailure {
+			return
+		}
+		a.notifier.Send(notifications.Notification{
+			Key:     presetStateNotificationKey(presetName),
+			Summary: fmt.Sprintf("Preset '%s' stopped: %s hook failed", presetName, hookErr.HookType),
+			Body:    hookErr.Err.Error(),
+			Urgency: notifications.UrgencyCritical,
+		})
+		return
+	}
+	if !a.notifyOpts.Crash {
+		return
+	}
+	body := err.Error()
+	if a.presets[presetIndex].Preset.AutorestartOnCrash {
+		body += "\nIt will be restarted automatically."
+	}
+	a.notifier.Send(notifications.Notification{
+		Key:     presetStateNotificationKey(presetName),
+		Summary: fmt.Sprintf("Kanata crashed (preset '%s')", presetName),
+		Body:    body,
+		Urgency: notifications.UrgencyCritical,
+	})
+}
+
+// `reason` - a sentence describing why restarts were stopped.
+func (a *SystrayApp) notifyRestartGiveUp(presetIndex int, reason string) {
+	if !a.notifyOpts.RestartGiveUp {
+		return
+	}
+	presetName := a.presets[presetIndex].PresetName
+	a.notifier.Send(notifications.Notification{
+		Key:     presetStateNotificationKey(presetName),
+		Summary: fmt.Sprintf("Stopped restarting preset '%s'", presetName),
+		Body:    reason + " Start the preset from the tray menu once the problem is fixed.",
+		Urgency: notifications.UrgencyCritical,
+	})
+}
+
+func (a *SystrayApp) notifyLayerChange(presetName string, layerName string) {
+	if !a.notifyOpts.LayerChange {
+		return
+	}
+	a.notifier.Send(notifications.Notification{
+		Key:     "layer:" + presetName,
+		Summary: "Layer: " + layerName,
+		Body:    "Preset: " + presetName,
+		Urgency: notifications.UrgencyLow,
+	})
+}
@@ -0,0 +1,160 @@
+// Package single_instance makes sure that only one kanata-tray runs per
+// config folder. Instances launched later hand off their intent (e.g. which
+// presets to start) to the already running instance and exit.
+package single_instance
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rszyma/kanata-tray/logging"
+	"github.com/rszyma/kanata-tray/os_specific"
+)
+
+const (
+	lockFileName   = "kanata-tray.lock"
+	socketFileName = "kanata-tray.instance.sock"
+	dialAttempts   = 5
+)
+
+// Returned by Acquire when another kanata-tray instance is already running.
+var ErrAlreadyRunning = errors.New("another kanata-tray instance is already running")
+
+// What a newly launched instance asks the running instance to do.
+type Handoff struct {
+	StartPresets []string
+}
+
+type handoffResponse struct {
+	Error string `json:",omitempty"`
+}
+
+type Instance struct {
+	lockFile *os.File
+	listener net.Listener
+}
+
+// Tries to become the only running kanata-tray instance for the given config folder.
+// Returns ErrAlreadyRunning if there's another instance running.
+func Acquire(configFolder string) (*Instance, error) {
+	lockFile, err := os.OpenFile(filepath.Join(configFolder, lockFileName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	err = os_specific.TryLockFile(lockFile)
+	if err != nil {
+		lockFile.Close()
+		if errors.Is(err, os_specific.ErrLocked) {
+			return nil, ErrAlreadyRunning
+		}
+		return nil, fmt.Errorf("failed to lock file: %v", err)
+	}
+	// Informational only, the lock itself is what matters.
+	if err := lockFile.Truncate(0); err == nil {
+		fmt.Fprintf(lockFile, "%d\n", os.Getpid())
+	}
+	// Listening starts right away, so that instances launched while this one
+	// is still starting up can connect. Their handoffs are handled once
+	// ListenForHandoffs is called.
+	listener, err := listen(filepath.Join(configFolder, socketFileName))
+	if err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("failed to listen for other instances: %v", err)
+	}
+	return &Instance{
+		lockFile: lockFile,
+		listener: listener,
+	}, nil
+}
+
+func listen(socketPath string) (net.Listener, error) {
+	// We hold the lock, so the socket file can only be a leftover from
+	// a previous instance that didn't exit cleanly.
+	err := os.Remove(socketPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+	}
+	return net.Listen("unix", socketPath)
+}
+
+// Handles handoffs from instances launched later, calling `handler` for
+// each of them. Blocks until accepting connections fails.
+func (i *Instance) ListenForHandoffs(handler func(Handoff) error) error {
+	defer i.listener.Close()
+	for {
+		conn, err := i.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handleConn(conn, handler)
+	}
+}
+
+func handleConn(conn net.Conn, handler func(Handoff) error) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	var handoff Handoff
+	var resp handoffResponse
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &handoff)
+	}
+	if err != nil {
+		logging.Errorf(nil, "failed to read handoff from another instance: %v", err)
+		resp.Error = fmt.Sprintf("invalid handoff: %v", err)
+	} else {
+		logging.Infof(nil, "Another kanata-tray instance was launched, handoff: %+v", handoff)
+		if err := handler(handoff); err != nil {
+			resp.Error = err.Error()
+		}
+	}
+	respBytes, _ := json.Marshal(resp)
+	conn.Write(append(respBytes, '\n'))
+}
+
+// Sends a handoff to the already running instance.
+func SendHandoff(configFolder string, handoff Handoff) error {
+	socketPath := filepath.Join(configFolder, socketFileName)
+	var conn net.Conn
+	var err error
+	// The running instance may have taken the lock, but not started listening yet.
+	for attempt := 1; ; attempt++ {
+		conn, err = net.DialTimeout("unix", socketPath, 3*time.Second)
+		if err == nil || attempt == dialAttempts {
+			break
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to the running instance: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	handoffBytes, err := json.Marshal(handoff)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(handoffBytes, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to send handoff: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	var resp handoffResponse
+	err = json.Unmarshal(line, &resp)
+	if err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package single_instance
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func (i *Instance) close() {
+	i.listener.Close()
+	i.lockFile.Close()
+}
+
+func TestAcquire(t *testing.T) {
+	configFolder := t.TempDir()
+	instance, err := Acquire(configFolder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(configFolder); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("second Acquire returned %v, want ErrAlreadyRunning", err)
+	}
+	instance.close()
+
+	// The lock is released together with the lock file.
+	instance, err = Acquire(configFolder)
+	if err != nil {
+		t.Fatalf("Acquire after the previous instance exited failed: %v", err)
+	}
+	instance.close()
+}
+
+func TestSendHandoff(t *testing.T) {
+	configFolder := t.TempDir()
+	instance, err := Acquire(configFolder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.close()
+
+	// Handoff is sent before the running instance is ready to handle it.
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- SendHandoff(configFolder, Handoff{StartPresets: []string{"main", "other"}})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	handoffs := make(chan Handoff, 2)
+	go instance.ListenForHandoffs(func(handoff Handoff) error {
+		handoffs <- handoff
+		if slices.Contains(handoff.StartPresets, "missing") {
+			return fmt.Errorf("preset 'missing' doesn't exist")
+		}
+		return nil
+	})
+
+	if err := <-sendErr; err != nil {
+		t.Fatalf("SendHandoff failed: %v", err)
+	}
+	if handoff := <-handoffs; !slices.Equal(handoff.StartPresets, []string{"main", "other"}) {
+		t.Errorf("received handoff %+v", handoff)
+	}
+
+	// Errors of the running instance are returned to the sender.
+	err = SendHandoff(configFolder, Handoff{StartPresets: []string{"missing"}})
+	if err == nil || err.Error() != "preset 'missing' doesn't exist" {
+		t.Errorf("SendHandoff returned %v, want error from the running instance", err)
+	}
+	<-handoffs
+}
+
+func TestSendHandoffNoInstance(t *testing.T) {
+	if err := SendHandoff(t.TempDir(), Handoff{}); err == nil {
+		t.Error("SendHandoff succeeded without a running instance")
+	}
+}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/logging"
+	"github.com/rszyma/kanata-tray/runner"
+)
+
+type DiagnosticSeverity string
+
+const (
+	// Preset can't be run.
+	SeverityError DiagnosticSeverity = "error"
+	// Preset can be run, but something will likely not work as expected.
+	SeverityWarning DiagnosticSeverity = "warning"
+)
+
+// A problem with preset configuration, found before running the preset.
+type Diagnostic struct {
+	Severity DiagnosticSeverity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+}
+
+// Checks presets for problems that would otherwise surface only when
+// a preset is run. Found problems are stored in `Diagnostics` of each entry.
+// Presets with errors are marked as not selectable.
+//
+// Must be called after the working directory is set to config folder,
+// since relative paths in presets are relative to it.
+func ValidatePresets(presets []PresetMenuEntry, concurrentPresets bool, configFolder string) {
+	customIconsFolder := filepath.Join(configFolder, "icons")
+	for i := range presets {
+		entry := &presets[i]
+		preset := entry.Preset
+		var diags []Diagnostic
+		addf := func(severity DiagnosticSeverity, format string, args ...any) {
+			diags = append(diags, Diagnostic{Severity: severity, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if preset.KanataConfig != "" {
+			if _, err := os.Stat(preset.KanataConfig); err != nil {
+				addf(SeverityError, "kanata_config '%s' can't be accessed: %v", preset.KanataConfig, err)
+			}
+		}
+		if _, err := runner.ResolveKanataExecutable(preset.KanataExecutable); err != nil {
+			if preset.KanataExecutable == "" {
+				addf(SeverityError, "kanata_executable is not set and kanata was not found in PATH: %v", err)
+			} else {
+				addf(SeverityError, "kanata_executable can't be resolved: %v", err)
+			}
+		}
+
+		if concurrentPresets && preset.TcpPort != config.TcpPortAuto {
+			// The preset declared first keeps the port.
+			for _, other := range presets[:i] {
+				if other.Preset.TcpPort == preset.TcpPort {
+					addf(SeverityError, "tcp_port %d is already used by preset '%s'", preset.TcpPort, other.PresetName)
+					break
+				}
+			}
+		}
+
+		layerNames := make([]string, 0, len(preset.LayerIcons))
+		for layerName := range preset.LayerIcons {
+			layerNames = append(layerNames, layerName)
+		}
+		sort.Strings(layerNames)
+		for _, layerName := range layerNames {
+			if _, err := readIconInFolder(preset.LayerIcons[layerName], customIconsFolder); err != nil {
+				addf(SeverityWarning, "icon of layer '%s' can't be loaded: %v", layerName, err)
+			}
+		}
+
+		for _, hooks := range hooksByType(preset.Hooks) {
+			for _, hook := range hooks.hooks {
+				if hook.Cwd != "" {
+					if info, err := os.Stat(hook.Cwd); err != nil {
+						addf(SeverityError, "cwd of %s hook %#v can't be accessed: %v", hooks.hookType, hook.Args, err)
+					} else if !info.IsDir() {
+						addf(SeverityError, "cwd of %s hook %#v is not a directory", hooks.hookType, hook.Args)
+					}
+				}
+				// The executable may be resolvable only in hook's own environment.
+				if err := lookPathOfHook(hook.Args); err != nil {
+					addf(SeverityWarning, "executable of %s hook %#v can't be resolved: %v", hooks.hookType, hook.Args, err)
+				}
+			}
+		}
+
+		entry.Diagnostics = diags
+		entry.IsSelectable = !slices.ContainsFunc(diags, func(d Diagnostic) bool {
+			return d.Severity == SeverityError
+		})
+	}
+}
+
+// Resolves the program run by a hook. With a `cmd_template` consisting of just
+// `{}` (the default on Windows), the whole hook command, including arguments,
+// is the only arg, so the program is its first (possibly quoted) word.
+func lookPathOfHook(args []string) error {
+	_, err := exec.LookPath(args[0])
+	if err == nil || len(args) != 1 {
+		return err
+	}
+	command := strings.TrimSpace(args[0])
+	program := command
+	if quoted, ok := strings.CutPrefix(command, `"`); ok {
+		program, _, _ = strings.Cut(quoted, `"`)
+	} else if fields := strings.Fields(command); len(fields) > 0 {
+		program = fields[0]
+	}
+	if program == command {
+		return err
+	}
+	_, err = exec.LookPath(program)
+	return err
+}
+
+// Checks layer icons from `defaults` section, which are not specific to any preset.
+func ValidateDefaultLayerIcons(cfg *config.Config, configFolder string) []Diagnostic {
+	customIconsFolder := filepath.Join(configFolder, "icons")
+	var diags []Diagnostic
+	layerNames := make([]string, 0, len(cfg.PresetDefaults.LayerIcons))
+	for layerName := range cfg.PresetDefaults.LayerIcons {
+		layerNames = append(layerNames, layerName)
+	}
+	sort.Strings(layerNames)
+	for _, layerName := range layerNames {
+		if _, err := readIconInFolder(cfg.PresetDefaults.LayerIcons[layerName], customIconsFolder); err != nil {
+			diags = append(diags, Diagnostic{
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("icon of layer '%s' can't be loaded: %v", layerName, err),
+			})
+		}
+	}
+	return diags
+}
+
+// Returns an error describing why the preset can't be run, or nil if it can.
+func (m *PresetMenuEntry) validationErr() error {
+	for _, d := range m.Diagnostics {
+		if d.Severity == SeverityError {
+			return fmt.Errorf("preset has invalid configuration: %s", d.Message)
+		}
+	}
+	return nil
+}
+
+func logPresetDiagnostics(presets []PresetMenuEntry) {
+	for _, entry := range presets {
+		fields := logging.Fields{"preset": entry.PresetName, "event": "preset_diagnostic"}
+		for _, d := range entry.Diagnostics {
+			switch d.Severity {
+			case SeverityError:
+				logging.Errorf(fields, "Preset '%s' can't be run: %s", entry.PresetName, d.Message)
+			case SeverityWarning:
+				logging.Warnf(fields, "Preset '%s': %s", entry.PresetName, d.Message)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rszyma/kanata-tray/config"
+)
+
+func TestLookPathOfHook(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := [][]string{
+		{exe, "-c", "anything"},
+		{exe},
+		{`"` + exe + `" --flag "quoted arg"`},
+	}
+	if !strings.ContainsRune(exe, ' ') {
+		// e.g. cmd_template = ["{}"] with arguments in hook command
+		valid = append(valid, []string{exe + " --flag value"})
+	}
+	for _, args := range valid {
+		if err := lookPathOfHook(args); err != nil {
+			t.Errorf("lookPathOfHook(%q) = %v, want nil", args, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"kanata-tray-missing-program"},
+		{"kanata-tray-missing-program --flag"},
+		{`"kanata-tray-missing-program" --flag`},
+		{"kanata-tray-missing-program", exe},
+	}
+	for _, args := range invalid {
+		if err := lookPathOfHook(args); err == nil {
+			t.Errorf("lookPathOfHook(%q) = nil, want an error", args)
+		}
+	}
+}
+
+func TestValidatePresetsDuplicateTcpPort(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newEntry := func(name string, port int) PresetMenuEntry {
+		return PresetMenuEntry{
+			PresetName: name,
+			Preset:     config.Preset{KanataExecutable: exe, TcpPort: port},
+		}
+	}
+	presets := []PresetMenuEntry{
+		newEntry("first", 5829),
+		newEntry("second", 5829),
+		newEntry("auto1", config.TcpPortAuto),
+		newEntry("auto2", config.TcpPortAuto),
+	}
+	ValidatePresets(presets, true, t.TempDir())
+	for _, entry := range presets {
+		wantSelectable := entry.PresetName != "second"
+		if entry.IsSelectable != wantSelectable {
+			t.Errorf("preset '%s': IsSelectable = %t, want %t (diagnostics: %v)", entry.PresetName, entry.IsSelectable, wantSelectable, entry.Diagnostics)
+		}
+	}
+
+	// Without concurrent presets, the same port can be reused.
+	ValidatePresets(presets, false, t.TempDir())
+	if !presets[1].IsSelectable {
+		t.Errorf("preset 'second' is not selectable without concurrent presets: %v", presets[1].Diagnostics)
+	}
+}
@@ -3,18 +3,24 @@ package config
 import (
 	"bytes"
 	"fmt"
+	"image/color"
 	"os"
+	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/elliotchance/orderedmap/v2"
 	"github.com/k0kubun/pp/v3"
 	"github.com/kr/pretty"
-	"github.com/labstack/gommon/log"
 	"github.com/pelletier/go-toml/v2"
 	tomlu "github.com/pelletier/go-toml/v2/unstable"
 
 	_ "embed"
+
+	"github.com/rszyma/kanata-tray/logging"
 )
 
 //go:embed default_config.toml
@@ -30,11 +36,21 @@ type Preset struct {
 	Autorun            bool
 	KanataExecutable   string
 	KanataConfig       string
-	TcpPort            int
+	TcpPort            int // TcpPortAuto if a free port should be picked when the preset is run
 	LayerIcons         map[string]string
 	Hooks              Hooks
 	ExtraArgs          []string
 	AutorestartOnCrash bool
+	AutorestartPolicy  RestartPolicy
+	// How long to wait for kanata to open its TCP port after starting.
+	StartupTimeout time.Duration
+	// When a badge with layer initials is drawn on the tray icon.
+	LayerBadge LayerBadgeMode
+	// Colors of layer badges by layer name. Like layer icons, these
+	// are not inherited from defaults, but looked up there as a fallback.
+	LayerBadgeColors map[string]color.RGBA
+	// Used with `icon_policy = "priority"`. Higher value wins.
+	IconPriority int
 }
 
 func (m *Preset) GoString() string {
@@ -43,17 +59,118 @@ func (m *Preset) GoString() string {
 }
 
 type GeneralConfigOptions struct {
-	AllowConcurrentPresets bool
-	ControlServerEnable    bool
-	ControlServerPort      int
+	AllowConcurrentPresets  bool
+	ControlServerEnable     bool
+	ControlServerPort       int
+	ControlServerTransport  string // "tcp" or "unix"
+	ControlServerSocketPath string // empty means default location
+	ControlServerSocketMode os.FileMode
+	// If ControlServerRequireToken is set and ControlServerToken is empty,
+	// a token is generated and stored in config folder.
+	ControlServerRequireToken bool
+	ControlServerToken        string
+	// Kanata logs of a preset are rotated when they reach this size.
+	KanataLogMaxSize int64
+	// Number of rotated (and separately, crash) kanata logs kept per preset.
+	KanataLogMaxFiles int
+	LogFormat         string // "text" or "json"
+	// Events on which desktop notifications are sent.
+	NotifyOnCrash         bool
+	NotifyOnRestartGiveUp bool
+	NotifyOnHookFailure   bool
+	NotifyOnLayerChange   bool
+	// Spinner while a preset is starting and blinking icon after a crash.
+	AnimateStatusIcons bool
+	// Number of times the icon blinks on layer change.
+	LayerChangeFlashes int
+	// How the tray icon is chosen when multiple presets are running.
+	IconPolicy IconPolicy
+	// Templates of tray tooltip and preset menu titles, see `TemplatePlaceholders`.
+	Tooltip   string
+	MenuTitle string
+}
+
+type LayerBadgeMode string
+
+const (
+	LayerBadgeOff LayerBadgeMode = "off"
+	// Only layers without a custom icon get a badge (drawn on the default icon).
+	LayerBadgeUnmapped LayerBadgeMode = "unmapped"
+	LayerBadgeAll      LayerBadgeMode = "all"
+)
+
+type IconPolicy string
+
+const (
+	// Icon of the preset that most recently started, crashed or switched layer.
+	IconPolicyFocus IconPolicy = "focus"
+	// Icon of the running preset with the highest `icon_priority`.
+	// On ties, the preset declared first in config wins.
+	IconPolicyPriority IconPolicy = "priority"
+	// Icons of all running presets side by side.
+	IconPolicyComposite IconPolicy = "composite"
+)
+
+// Placeholders that can be used in `tooltip` and `menu_title` templates.
+var TemplatePlaceholders = []string{"{preset}", "{layer}", "{status}", "{marker}"}
+
+var templatePlaceholderRegex = regexp.MustCompile(`\{[^{}]*\}`)
+
+func validateTemplate(fieldName string, template string) error {
+	for _, placeholder := range templatePlaceholderRegex.FindAllString(template, -1) {
+		if !slices.Contains(TemplatePlaceholders, placeholder) {
+			return fmt.Errorf("%s: unknown placeholder '%s', expected one of: %s",
+				fieldName, placeholder, strings.Join(TemplatePlaceholders, ", "))
+		}
+	}
+	return nil
 }
 
-// Parsed hooks that contain list of args.
+// Value of `Preset.TcpPort` set by `tcp_port = "auto"`.
+const TcpPortAuto = 0
+
+// Controls how presets are automatically restarted (when `AutorestartOnCrash` is enabled).
+type RestartPolicy struct {
+	// Maximum number of restarts within `Window`. 0 means no limit.
+	MaxAttempts int
+	Window      time.Duration
+	// Delay before the first restart. Each next restart within `Window`
+	// is delayed `BackoffMultiplier` times longer, up to `MaxDelay` (0 means no limit).
+	InitialDelay      time.Duration
+	BackoffMultiplier float64
+	MaxDelay          time.Duration
+	// Whether to also restart when kanata exits by itself without an error.
+	RestartOnCleanExit bool
+}
+
+// Parsed hooks.
 type Hooks struct {
-	PreStart       [][]string
-	PostStart      [][]string
-	PostStartAsync [][]string
-	PostStop       [][]string
+	PreStart       []Hook
+	PostStart      []Hook
+	PostStartAsync []Hook
+	PostStop       []Hook
+	PreStop        []Hook
+	OnCrash        []Hook
+	OnLayerChange  []Hook
+	OnConfigReload []Hook
+	// Whether blocking hooks of the same type run one by one, instead of
+	// all hooks with the same `Order` running at the same time.
+	Sequential bool
+}
+
+type Hook struct {
+	Args []string
+	// Maximum runtime of the hook. 0 means default timeout for blocking
+	// hooks, and no timeout for async hooks.
+	Timeout time.Duration
+	// Blocking hooks with lower order run (and finish) before hooks with higher order.
+	Order int
+	// Whether a failure of this hook is ignored, instead of failing the preset.
+	AllowFailure bool
+	// Additional environment variables, in "key=value" form.
+	Env []string
+	// Working directory. Empty means kanata-tray working directory (config folder).
+	Cwd string
 }
 
 // =========
@@ -69,11 +186,16 @@ type preset struct {
 	Autorun            *bool             `toml:"autorun"`
 	KanataExecutable   *string           `toml:"kanata_executable"`
 	KanataConfig       *string           `toml:"kanata_config"`
-	TcpPort            *int              `toml:"tcp_port"`
+	TcpPort            any               `toml:"tcp_port"` // int64 or "auto"
 	LayerIcons         map[string]string `toml:"layer_icons"`
+	LayerBadge         *string           `toml:"layer_badge"`
+	LayerBadgeColors   map[string]string `toml:"layer_badge_colors"`
 	Hooks              *hooks            `toml:"hooks"`
 	ExtraArgs          extraArgs         `toml:"extra_args"`
 	AutorestartOnCrash *bool             `toml:"autorestart_on_crash"`
+	AutorestartPolicy  *restartPolicy    `toml:"autorestart_policy"`
+	StartupTimeout     *string           `toml:"startup_timeout"`
+	IconPriority       *int              `toml:"icon_priority"`
 }
 
 func (p *preset) applyDefaults(defaults *preset) {
@@ -94,6 +216,11 @@ func (p *preset) applyDefaults(defaults *preset) {
 	// if p.LayerIcons == nil {
 	// 	p.LayerIcons = defaults.LayerIcons
 	// }
+	//
+	// Same for layer badge colors.
+	if p.LayerBadge == nil {
+		p.LayerBadge = defaults.LayerBadge
+	}
 	if p.Hooks == nil {
 		p.Hooks = defaults.Hooks
 	}
@@ -103,6 +230,18 @@ func (p *preset) applyDefaults(defaults *preset) {
 	if p.AutorestartOnCrash == nil {
 		p.AutorestartOnCrash = defaults.AutorestartOnCrash
 	}
+	if p.AutorestartPolicy == nil {
+		p.AutorestartPolicy = defaults.AutorestartPolicy
+	} else if defaults.AutorestartPolicy != nil {
+		// Unlike hooks, policy fields are inherited from defaults one by one.
+		p.AutorestartPolicy.applyDefaults(defaults.AutorestartPolicy)
+	}
+	if p.StartupTimeout == nil {
+		p.StartupTimeout = defaults.StartupTimeout
+	}
+	if p.IconPriority == nil {
+		p.IconPriority = defaults.IconPriority
+	}
 }
 
 func (p *preset) intoExported() (*Preset, error) {
@@ -117,11 +256,33 @@ func (p *preset) intoExported() (*Preset, error) {
 		result.KanataConfig = *p.KanataConfig
 	}
 	if p.TcpPort != nil {
-		result.TcpPort = *p.TcpPort
+		x, err := parseTcpPort(p.TcpPort)
+		if err != nil {
+			return nil, err
+		}
+		result.TcpPort = x
 	}
 	if p.LayerIcons != nil {
 		result.LayerIcons = p.LayerIcons
 	}
+	if p.LayerBadge != nil {
+		switch mode := LayerBadgeMode(*p.LayerBadge); mode {
+		case LayerBadgeOff, LayerBadgeUnmapped, LayerBadgeAll:
+			result.LayerBadge = mode
+		default:
+			return nil, fmt.Errorf("invalid layer_badge '%s', expected 'off', 'unmapped' or 'all'", *p.LayerBadge)
+		}
+	}
+	if p.LayerBadgeColors != nil {
+		result.LayerBadgeColors = make(map[string]color.RGBA, len(p.LayerBadgeColors))
+		for layerName, s := range p.LayerBadgeColors {
+			c, err := parseHexColor(s)
+			if err != nil {
+				return nil, fmt.Errorf("layer_badge_colors: layer '%s': %v", layerName, err)
+			}
+			result.LayerBadgeColors[layerName] = c
+		}
+	}
 	if p.Hooks != nil {
 		x, err := p.Hooks.intoExported()
 		if err != nil {
@@ -139,21 +300,232 @@ func (p *preset) intoExported() (*Preset, error) {
 	if p.AutorestartOnCrash != nil {
 		result.AutorestartOnCrash = *p.AutorestartOnCrash
 	}
+	if p.AutorestartPolicy != nil {
+		x, err := p.AutorestartPolicy.intoExported()
+		if err != nil {
+			return nil, fmt.Errorf("autorestart_policy: %v", err)
+		}
+		result.AutorestartPolicy = *x
+	}
+	if p.StartupTimeout != nil {
+		x, err := parseDuration("startup_timeout", *p.StartupTimeout)
+		if err != nil {
+			return nil, err
+		}
+		result.StartupTimeout = x
+	}
+	if p.IconPriority != nil {
+		result.IconPriority = *p.IconPriority
+	}
+	return result, nil
+}
+
+type restartPolicy struct {
+	MaxAttempts        *int     `toml:"max_attempts"`
+	Window             *string  `toml:"window"`
+	InitialDelay       *string  `toml:"initial_delay"`
+	BackoffMultiplier  *float64 `toml:"backoff_multiplier"`
+	MaxDelay           *string  `toml:"max_delay"`
+	RestartOnCleanExit *bool    `toml:"restart_on_clean_exit"`
+}
+
+func (p *restartPolicy) applyDefaults(defaults *restartPolicy) {
+	if p.MaxAttempts == nil {
+		p.MaxAttempts = defaults.MaxAttempts
+	}
+	if p.Window == nil {
+		p.Window = defaults.Window
+	}
+	if p.InitialDelay == nil {
+		p.InitialDelay = defaults.InitialDelay
+	}
+	if p.BackoffMultiplier == nil {
+		p.BackoffMultiplier = defaults.BackoffMultiplier
+	}
+	if p.MaxDelay == nil {
+		p.MaxDelay = defaults.MaxDelay
+	}
+	if p.RestartOnCleanExit == nil {
+		p.RestartOnCleanExit = defaults.RestartOnCleanExit
+	}
+}
+
+func (p *restartPolicy) intoExported() (*RestartPolicy, error) {
+	result := &RestartPolicy{}
+	var err error
+	if p.MaxAttempts != nil {
+		if *p.MaxAttempts < 0 {
+			return nil, fmt.Errorf("max_attempts can't be negative")
+		}
+		result.MaxAttempts = *p.MaxAttempts
+	}
+	if p.Window != nil {
+		result.Window, err = parseDuration("window", *p.Window)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.InitialDelay != nil {
+		result.InitialDelay, err = parseDuration("initial_delay", *p.InitialDelay)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.BackoffMultiplier = 1
+	if p.BackoffMultiplier != nil {
+		if *p.BackoffMultiplier < 1 {
+			return nil, fmt.Errorf("backoff_multiplier must be at least 1")
+		}
+		result.BackoffMultiplier = *p.BackoffMultiplier
+	}
+	if p.MaxDelay != nil {
+		result.MaxDelay, err = parseDuration("max_delay", *p.MaxDelay)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.RestartOnCleanExit != nil {
+		result.RestartOnCleanExit = *p.RestartOnCleanExit
+	}
 	return result, nil
 }
 
+// Parses `tcp_port` value, which is either a port number or "auto".
+func parseTcpPort(value any) (int, error) {
+	switch value := value.(type) {
+	case int64:
+		if value < 1 || value > 65535 {
+			return 0, fmt.Errorf("tcp_port: %d is out of range 1-65535", value)
+		}
+		return int(value), nil
+	case string:
+		if value == "auto" {
+			return TcpPortAuto, nil
+		}
+	}
+	return 0, fmt.Errorf("tcp_port: expected a port number or \"auto\", got %#v", value)
+}
+
+// Parses a color in "#rrggbb" or "#rgb" format.
+func parseHexColor(s string) (color.RGBA, error) {
+	hex, ok := strings.CutPrefix(s, "#")
+	if ok && len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if !ok || len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s', expected '#rrggbb'", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s', expected '#rrggbb'", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// Parses a duration string like "1.5s" or "5m".
+func parseDuration(fieldName string, s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", fieldName, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s can't be negative", fieldName)
+	}
+	return d, nil
+}
+
 type generalConfigOptions struct {
-	AllowConcurrentPresets *bool `toml:"allow_concurrent_presets"`
-	ControlServerEnable    *bool `toml:"control_server_enable"`
-	ControlServerPort      *int  `toml:"control_server_port"`
+	AllowConcurrentPresets    *bool   `toml:"allow_concurrent_presets"`
+	ControlServerEnable       *bool   `toml:"control_server_enable"`
+	ControlServerPort         *int    `toml:"control_server_port"`
+	ControlServerTransport    *string `toml:"control_server_transport"`
+	ControlServerSocketPath   *string `toml:"control_server_socket_path"`
+	ControlServerSocketMode   *uint32 `toml:"control_server_socket_mode"`
+	ControlServerRequireToken *bool   `toml:"control_server_require_token"`
+	ControlServerToken        *string `toml:"control_server_token"`
+	KanataLogMaxSizeMb        *int    `toml:"kanata_log_max_size_mb"`
+	KanataLogMaxFiles         *int    `toml:"kanata_log_max_files"`
+	LogFormat                 *string `toml:"log_format"`
+	NotifyOnCrash             *bool   `toml:"notify_on_crash"`
+	NotifyOnRestartGiveUp     *bool   `toml:"notify_on_restart_give_up"`
+	NotifyOnHookFailure       *bool   `toml:"notify_on_hook_failure"`
+	NotifyOnLayerChange       *bool   `toml:"notify_on_layer_change"`
+	AnimateStatusIcons        *bool   `toml:"animate_status_icons"`
+	LayerChangeFlashes        *int    `toml:"layer_change_flashes"`
+	IconPolicy                *string `toml:"icon_policy"`
+	Tooltip                   *string `toml:"tooltip"`
+	MenuTitle                 *string `toml:"menu_title"`
+}
+
+func (g *generalConfigOptions) intoExported() (*GeneralConfigOptions, error) {
+	switch *g.ControlServerTransport {
+	case "tcp", "unix":
+	default:
+		return nil, fmt.Errorf("invalid control_server_transport '%s', expected 'tcp' or 'unix'", *g.ControlServerTransport)
+	}
+	if *g.ControlServerSocketMode > 0o777 {
+		return nil, fmt.Errorf("invalid control_server_socket_mode %#o", *g.ControlServerSocketMode)
+	}
+	if *g.KanataLogMaxSizeMb < 1 {
+		return nil, fmt.Errorf("kanata_log_max_size_mb must be at least 1")
+	}
+	if *g.KanataLogMaxFiles < 1 {
+		return nil, fmt.Errorf("kanata_log_max_files must be at least 1")
+	}
+	switch *g.LogFormat {
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("invalid log_format '%s', expected 'text' or 'json'", *g.LogFormat)
+	}
+	if *g.LayerChangeFlashes < 0 {
+		return nil, fmt.Errorf("layer_change_flashes can't be negative")
+	}
+	switch IconPolicy(*g.IconPolicy) {
+	case IconPolicyFocus, IconPolicyPriority, IconPolicyComposite:
+	default:
+		return nil, fmt.Errorf("invalid icon_policy '%s', expected 'focus', 'priority' or 'composite'", *g.IconPolicy)
+	}
+	if err := validateTemplate("tooltip", *g.Tooltip); err != nil {
+		return nil, err
+	}
+	if err := validateTemplate("menu_title", *g.MenuTitle); err != nil {
+		return nil, err
+	}
+	return &GeneralConfigOptions{
+		AllowConcurrentPresets:    *g.AllowConcurrentPresets,
+		ControlServerEnable:       *g.ControlServerEnable,
+		ControlServerPort:         *g.ControlServerPort,
+		ControlServerTransport:    *g.ControlServerTransport,
+		ControlServerSocketPath:   *g.ControlServerSocketPath,
+		ControlServerSocketMode:   os.FileMode(*g.ControlServerSocketMode),
+		ControlServerRequireToken: *g.ControlServerRequireToken,
+		ControlServerToken:        *g.ControlServerToken,
+		KanataLogMaxSize:          int64(*g.KanataLogMaxSizeMb) * 1024 * 1024,
+		KanataLogMaxFiles:         *g.KanataLogMaxFiles,
+		LogFormat:                 *g.LogFormat,
+		NotifyOnCrash:             *g.NotifyOnCrash,
+		NotifyOnRestartGiveUp:     *g.NotifyOnRestartGiveUp,
+		NotifyOnHookFailure:       *g.NotifyOnHookFailure,
+		NotifyOnLayerChange:       *g.NotifyOnLayerChange,
+		AnimateStatusIcons:        *g.AnimateStatusIcons,
+		LayerChangeFlashes:        *g.LayerChangeFlashes,
+		IconPolicy:                IconPolicy(*g.IconPolicy),
+		Tooltip:                   *g.Tooltip,
+		MenuTitle:                 *g.MenuTitle,
+	}, nil
 }
 
 type hooks struct {
 	CmdTemplate    []string `toml:"cmd_template"`
-	PreStart       []string `toml:"pre-start"` // TODO: rename to snake case.
-	PostStart      []string `toml:"post-start"`
-	PostStartAsync []string `toml:"post-start-async"`
-	PostStop       []string `toml:"post-stop"`
+	Sequential     *bool    `toml:"sequential"`
+	PreStart       []any    `toml:"pre-start"` // TODO: rename to snake case.
+	PostStart      []any    `toml:"post-start"`
+	PostStartAsync []any    `toml:"post-start-async"`
+	PostStop       []any    `toml:"post-stop"`
+	PreStop        []any    `toml:"pre-stop"`
+	OnCrash        []any    `toml:"on-crash"`
+	OnLayerChange  []any    `toml:"on-layer-change"`
+	OnConfigReload []any    `toml:"on-config-reload"`
 }
 
 type cmdTempl struct {
@@ -189,12 +561,76 @@ func (t *cmdTempl) apply(s string) (finalArgv []string) {
 	return finalArgv
 }
 
-func (t *cmdTempl) applyMany(xs []string) [][]string {
-	var results [][]string
-	for _, x := range xs {
-		results = append(results, t.apply(x))
+// Parses hook entries. Each entry is either a command string, or a table:
+// { cmd = "...", timeout = "10s", order = 1, allow_failure = true, env = { KEY = "value" }, cwd = "..." }
+func (t *cmdTempl) parseHooks(hookType string, entries []any) ([]Hook, error) {
+	var results []Hook
+	for i, entry := range entries {
+		hook, err := t.parseHook(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s hook [%d]: %v", hookType, i, err)
+		}
+		results = append(results, hook)
+	}
+	return results, nil
+}
+
+func (t *cmdTempl) parseHook(entry any) (Hook, error) {
+	switch entry := entry.(type) {
+	case string:
+		return Hook{Args: t.apply(entry)}, nil
+	case map[string]any:
+		var hook Hook
+		cmd, ok := entry["cmd"].(string)
+		if !ok {
+			return hook, fmt.Errorf("'cmd' must be set to a string")
+		}
+		hook.Args = t.apply(cmd)
+		for key, value := range entry {
+			var ok bool
+			switch key {
+			case "cmd":
+				ok = true
+			case "timeout":
+				var s string
+				if s, ok = value.(string); ok {
+					var err error
+					hook.Timeout, err = parseDuration("timeout", s)
+					if err != nil {
+						return hook, err
+					}
+				}
+			case "order":
+				var order int64
+				order, ok = value.(int64)
+				hook.Order = int(order)
+			case "allow_failure":
+				hook.AllowFailure, ok = value.(bool)
+			case "env":
+				var env map[string]any
+				if env, ok = value.(map[string]any); ok {
+					for k, v := range env {
+						s, isString := v.(string)
+						if !isString {
+							return hook, fmt.Errorf("value of env variable '%s' must be a string", k)
+						}
+						hook.Env = append(hook.Env, k+"="+s)
+					}
+					// map iteration order is random
+					slices.Sort(hook.Env)
+				}
+			case "cwd":
+				hook.Cwd, ok = value.(string)
+			default:
+				return hook, fmt.Errorf("unknown option '%s'", key)
+			}
+			if !ok {
+				return hook, fmt.Errorf("invalid type of '%s': %T", key, value)
+			}
+		}
+		return hook, nil
 	}
-	return results
+	return Hook{}, fmt.Errorf("expected a string or a table, got %T", entry)
 }
 
 func (p *hooks) intoExported() (*Hooks, error) {
@@ -210,12 +646,43 @@ func (p *hooks) intoExported() (*Hooks, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parsing cmd_template: %w", err)
 	}
-	return &Hooks{
-		PreStart:       templ.applyMany(p.PreStart),
-		PostStart:      templ.applyMany(p.PostStart),
-		PostStartAsync: templ.applyMany(p.PostStartAsync),
-		PostStop:       templ.applyMany(p.PostStop),
-	}, nil
+	result := &Hooks{}
+	if p.Sequential != nil {
+		result.Sequential = *p.Sequential
+	}
+	result.PreStart, err = templ.parseHooks("pre-start", p.PreStart)
+	if err != nil {
+		return nil, err
+	}
+	result.PostStart, err = templ.parseHooks("post-start", p.PostStart)
+	if err != nil {
+		return nil, err
+	}
+	result.PostStartAsync, err = templ.parseHooks("post-start-async", p.PostStartAsync)
+	if err != nil {
+		return nil, err
+	}
+	result.PostStop, err = templ.parseHooks("post-stop", p.PostStop)
+	if err != nil {
+		return nil, err
+	}
+	result.PreStop, err = templ.parseHooks("pre-stop", p.PreStop)
+	if err != nil {
+		return nil, err
+	}
+	result.OnCrash, err = templ.parseHooks("on-crash", p.OnCrash)
+	if err != nil {
+		return nil, err
+	}
+	result.OnLayerChange, err = templ.parseHooks("on-layer-change", p.OnLayerChange)
+	if err != nil {
+		return nil, err
+	}
+	result.OnConfigReload, err = templ.parseHooks("on-config-reload", p.OnConfigReload)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 type extraArgs []string
@@ -230,6 +697,16 @@ func (e extraArgs) intoExported() ([]string, error) {
 }
 
 func ReadConfigOrCreateIfNotExist(configFilePath string) (*Config, error) {
+	return readConfig(configFilePath, true)
+}
+
+// Same as ReadConfigOrCreateIfNotExist, but if the config file doesn't exist,
+// default config is returned without writing it to disk.
+func ReadConfig(configFilePath string) (*Config, error) {
+	return readConfig(configFilePath, false)
+}
+
+func readConfig(configFilePath string, createIfNotExist bool) (*Config, error) {
 	var cfg *config = &config{}
 	// Golang map don't keep track of insertion order, so we need to get the
 	// order of declarations in toml separately.
@@ -247,10 +724,12 @@ func ReadConfigOrCreateIfNotExist(configFilePath string) (*Config, error) {
 
 	// Does the file not exist?
 	if _, err := os.Stat(configFilePath); os.IsNotExist(err) {
-		log.Infof("Config file doesn't exist. Creating default config. Path: '%s'", configFilePath)
-		err = os.WriteFile(configFilePath, []byte(defaultConfigContent), os.FileMode(0600))
-		if err != nil {
-			return nil, fmt.Errorf("failed to write default config file to '%s': %v", configFilePath, err)
+		if createIfNotExist {
+			logging.Infof(nil, "Config file doesn't exist. Creating default config. Path: '%s'", configFilePath)
+			err = os.WriteFile(configFilePath, []byte(defaultConfigContent), os.FileMode(0600))
+			if err != nil {
+				return nil, fmt.Errorf("failed to write default config file to '%s': %v", configFilePath, err)
+			}
 		}
 	} else {
 		// Load the existing file.
@@ -281,14 +760,14 @@ func ReadConfigOrCreateIfNotExist(configFilePath string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	generalExported, err := cfg.General.intoExported()
+	if err != nil {
+		return nil, err
+	}
 	var cfg2 *Config = &Config{
 		PresetDefaults: *defaultsExported,
-		General: GeneralConfigOptions{
-			AllowConcurrentPresets: *cfg.General.AllowConcurrentPresets,
-			ControlServerEnable:    *cfg.General.ControlServerEnable,
-			ControlServerPort:      *cfg.General.ControlServerPort,
-		},
-		Presets: NewOrderedMap[string, *Preset](),
+		General:        *generalExported,
+		Presets:        NewOrderedMap[string, *Preset](),
 	}
 
 	for _, layerName := range layersNames {
@@ -304,7 +783,7 @@ func ReadConfigOrCreateIfNotExist(configFilePath string) (*Config, error) {
 		cfg2.Presets.Set(layerName, exported)
 	}
 
-	log.Debugf("loaded config: %s", pretty.Sprint(cfg2))
+	logging.Debugf(nil, "loaded config: %s", pretty.Sprint(cfg2))
 	return cfg2, nil
 }
 
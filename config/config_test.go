@@ -0,0 +1,132 @@
+package config
+
+import (
+	"image/color"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHook(t *testing.T) {
+	templ, err := newCmdTemplFromRaw([]string{"/bin/sh", "-c", "{}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := templ.parseHook("echo hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bin/sh", "-c", "echo hi"}; !slices.Equal(hook.Args, want) {
+		t.Errorf("Args = %v, want %v", hook.Args, want)
+	}
+
+	hook, err = templ.parseHook(map[string]any{
+		"cmd":           "echo hi",
+		"timeout":       "1.5s",
+		"order":         int64(2),
+		"allow_failure": true,
+		"env":           map[string]any{"B": "2", "A": "1"},
+		"cwd":           "/tmp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.Timeout != 1500*time.Millisecond || hook.Order != 2 || !hook.AllowFailure || hook.Cwd != "/tmp" {
+		t.Errorf("unexpected hook: %#v", hook)
+	}
+	if want := []string{"A=1", "B=2"}; !slices.Equal(hook.Env, want) {
+		t.Errorf("Env = %v, want %v", hook.Env, want)
+	}
+}
+
+func TestParseHookErrors(t *testing.T) {
+	templ, err := newCmdTemplFromRaw([]string{"{}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		entry   any
+		wantErr string
+	}{
+		{int64(1), "expected a string or a table"},
+		{map[string]any{"timeout": "1s"}, "'cmd' must be set"},
+		{map[string]any{"cmd": "x", "timeout": "soon"}, "invalid timeout"},
+		{map[string]any{"cmd": "x", "timeout": "-1s"}, "can't be negative"},
+		{map[string]any{"cmd": "x", "order": "1"}, "invalid type of 'order'"},
+		{map[string]any{"cmd": "x", "env": map[string]any{"A": int64(1)}}, "must be a string"},
+		{map[string]any{"cmd": "x", "retries": int64(1)}, "unknown option 'retries'"},
+	}
+	for _, tt := range tests {
+		_, err := templ.parseHook(tt.entry)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseHook(%#v) error = %v, want error containing %q", tt.entry, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewCmdTemplFromRaw(t *testing.T) {
+	for _, raw := range [][]string{{"sh", "-c"}, {"{}", "{}"}} {
+		if _, err := newCmdTemplFromRaw(raw); err == nil {
+			t.Errorf("newCmdTemplFromRaw(%q) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestParseTcpPort(t *testing.T) {
+	tests := []struct {
+		value   any
+		want    int
+		wantErr bool
+	}{
+		{int64(5829), 5829, false},
+		{int64(1), 1, false},
+		{int64(65535), 65535, false},
+		{"auto", TcpPortAuto, false},
+		{int64(0), 0, true},
+		{int64(65536), 0, true},
+		{"5829", 0, true},
+		{1.5, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTcpPort(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTcpPort(%#v) = (%d, %v), want (%d, error=%t)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    color.RGBA
+		wantErr bool
+	}{
+		{"#ff8000", color.RGBA{R: 0xff, G: 0x80, B: 0x00, A: 0xff}, false},
+		{"#FF8000", color.RGBA{R: 0xff, G: 0x80, B: 0x00, A: 0xff}, false},
+		{"#f80", color.RGBA{R: 0xff, G: 0x88, B: 0x00, A: 0xff}, false},
+		{"ff8000", color.RGBA{}, true},
+		{"#ff800", color.RGBA{}, true},
+		{"#gg8000", color.RGBA{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseHexColor(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseHexColor(%q) = (%v, %v), want (%v, error=%t)", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	for _, template := range []string{"", "kanata", "{preset}: {layer} ({status})", "{marker}Preset: {preset}"} {
+		if err := validateTemplate("tooltip", template); err != nil {
+			t.Errorf("validateTemplate(%q) = %v, want nil", template, err)
+		}
+	}
+	for _, template := range []string{"{name}", "{preset} {}", "{Layer}"} {
+		if err := validateTemplate("tooltip", template); err == nil {
+			t.Errorf("validateTemplate(%q) = nil, want an error", template)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/rszyma/kanata-tray/logging"
+)
+
+// Editors usually emit a burst of events on a single save, so we wait
+// until the file settles down before calling `onChange`.
+const watchDebounce = 300 * time.Millisecond
+
+// Watches config file for changes and calls `onChange` after each change.
+// Calls of `onChange` never overlap. Blocks until ctx is cancelled or the watcher fails.
+//
+// The parent directory is watched instead of the file itself, because many
+// editors save files by writing a new file and renaming it over the old one,
+// which would silently end a watch placed directly on the file.
+func WatchConfigFile(ctx context.Context, configFilePath string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("fsnotify.NewWatcher: %v", err)
+	}
+	defer watcher.Close()
+
+	configFilePath = filepath.Clean(configFilePath)
+	err = watcher.Add(filepath.Dir(configFilePath))
+	if err != nil {
+		return fmt.Errorf("failed to watch config folder: %v", err)
+	}
+
+	// `onChange` is called from this goroutine, so that reloads never overlap.
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-debounce:
+			debounce = nil
+			onChange()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return fmt.Errorf("watcher events channel closed")
+			}
+			if filepath.Clean(event.Name) != configFilePath {
+				continue
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			logging.Debugf(nil, "config file event: %s", event)
+			debounce = time.After(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return fmt.Errorf("watcher errors channel closed")
+			}
+			logging.Errorf(nil, "config file watcher error: %v", err)
+		}
+	}
+}
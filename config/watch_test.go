@@ -0,0 +1,72 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kanata-tray.toml")
+	if err := os.WriteFile(path, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls, running atomic.Int32
+	changed := make(chan struct{}, 10)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- WatchConfigFile(ctx, path, func() {
+			if running.Add(1) != 1 {
+				t.Error("onChange calls overlap")
+			}
+			time.Sleep(2 * watchDebounce)
+			running.Add(-1)
+			calls.Add(1)
+			changed <- struct{}{}
+		})
+	}()
+	time.Sleep(100 * time.Millisecond) // let the watcher start
+
+	// Other files in the folder are ignored.
+	os.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0o644)
+	// A burst of writes results in a single reload.
+	for i := 0; i < 5; i++ {
+		os.WriteFile(path, []byte{byte('a' + i)}, 0o644)
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange was not called")
+	}
+	// Changes made while a reload is in progress are not lost.
+	os.WriteFile(path, []byte("z"), 0o644)
+	time.Sleep(watchDebounce / 2)
+	os.WriteFile(path, []byte("zz"), 0o644)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange was not called after the second change")
+	}
+	time.Sleep(3 * watchDebounce)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("onChange called %d times, want 2", n)
+	}
+
+	cancel()
+	select {
+	case err := <-watchErr:
+		if err != nil {
+			t.Errorf("WatchConfigFile = %v, want nil after cancel", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchConfigFile didn't return after cancel")
+	}
+}
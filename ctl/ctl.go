@@ -0,0 +1,386 @@
+// Package ctl implements `kanata-tray ctl` - a command-line client for
+// controlling an already running kanata-tray through its control server.
+package ctl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+	"github.com/rszyma/kanata-tray/config"
+)
+
+type command struct {
+	usage   string
+	help    string
+	argsLen int
+	// Returns HTTP method and path of the request to send.
+	request func(args []string) (method string, path string)
+	// Prints response data in human-readable form. If nil, response message is printed.
+	printData func(data json.RawMessage) error
+}
+
+func presetPath(format string, args []string) string {
+	escaped := make([]any, len(args))
+	for i, arg := range args {
+		escaped[i] = url.PathEscape(arg)
+	}
+	return fmt.Sprintf(format, escaped...)
+}
+
+var commands = map[string]command{
+	"status": {
+		usage:     "status",
+		help:      "Print status of all presets",
+		request:   func(args []string) (string, string) { return http.MethodGet, "/status" },
+		printData: printTrayStatus,
+	},
+	"start": {
+		usage:   "start <preset>",
+		help:    "Start a preset",
+		argsLen: 1,
+		request: func(args []string) (string, string) {
+			return http.MethodPost, presetPath("/start/%s", args)
+		},
+	},
+	"stop": {
+		usage:   "stop <preset>",
+		help:    "Stop a preset",
+		argsLen: 1,
+		request: func(args []string) (string, string) {
+			return http.MethodPost, presetPath("/stop/%s", args)
+		},
+	},
+	"toggle": {
+		usage:   "toggle <preset>",
+		help:    "Start or stop a preset",
+		argsLen: 1,
+		request: func(args []string) (string, string) {
+			return http.MethodPost, presetPath("/toggle/%s", args)
+		},
+	},
+	"stop-all": {
+		usage:   "stop-all",
+		help:    "Stop all running presets",
+		request: func(args []string) (string, string) { return http.MethodPost, "/stop_all" },
+	},
+	"start-all-default": {
+		usage:   "start-all-default",
+		help:    "Start all presets with autorun enabled",
+		request: func(args []string) (string, string) { return http.MethodPost, "/start_all_default" },
+	},
+	"toggle-all-default": {
+		usage:   "toggle-all-default",
+		help:    "Start or stop all presets with autorun enabled",
+		request: func(args []string) (string, string) { return http.MethodPost, "/toggle_all_default" },
+	},
+	"layer": {
+		usage:   "layer <preset> <layer>",
+		help:    "Switch kanata layer in a running preset",
+		argsLen: 2,
+		request: func(args []string) (string, string) {
+			return http.MethodPost, presetPath("/presets/%s/layer/%s", args)
+		},
+	},
+	"fake-key": {
+		usage:   "fake-key <preset> <key> <press|release|tap|toggle>",
+		help:    "Act on a kanata fake key in a running preset",
+		argsLen: 3,
+		request: func(args []string) (string, string) {
+			return http.MethodPost, presetPath("/presets/%s/fake_key/%s/%s", args)
+		},
+	},
+	"reload": {
+		usage:   "reload <preset>",
+		help:    "Reload kanata config in a running preset",
+		argsLen: 1,
+		request: func(args []string) (string, string) {
+			return http.MethodPost, presetPath("/presets/%s/reload", args)
+		},
+	},
+}
+
+// Order in which commands are listed in help.
+var commandOrder = []string{
+	"status", "start", "stop", "toggle", "stop-all", "start-all-default",
+	"toggle-all-default", "layer", "fake-key", "reload", "events",
+}
+
+func printUsage(flags *pflag.FlagSet) {
+	fmt.Println("kanata-tray ctl: control a running kanata-tray")
+	fmt.Println()
+	fmt.Println("Usage: kanata-tray ctl [options] <command> [args...]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	for _, name := range commandOrder {
+		if name == "events" {
+			fmt.Fprintf(w, "  events\tPrint preset and layer events as they happen\n")
+			continue
+		}
+		cmd := commands[name]
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.usage, cmd.help)
+	}
+	w.Flush()
+	fmt.Println()
+	fmt.Println("Options:")
+	flags.PrintDefaults()
+}
+
+// Runs `kanata-tray ctl` with the given args (not including "ctl").
+// Returns process exit code.
+func Run(args []string, configFilePath string, configFolder string) int {
+	flags := pflag.NewFlagSet("ctl", pflag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print raw JSON responses.")
+	help := flags.BoolP("help", "h", false, "Print help and exit.")
+	flags.SetInterspersed(true)
+	err := flags.Parse(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *help || flags.NArg() == 0 {
+		printUsage(flags)
+		if *help {
+			return 0
+		}
+		return 2
+	}
+
+	cmdName := flags.Arg(0)
+	cmdArgs := flags.Args()[1:]
+
+	client, err := newClient(configFilePath, configFolder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if cmdName == "events" {
+		err = client.streamEvents(*jsonOutput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	cmd, ok := commands[cmdName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: unknown command '%s'\n", cmdName)
+		return 2
+	}
+	if len(cmdArgs) != cmd.argsLen {
+		fmt.Fprintf(os.Stderr, "usage: kanata-tray ctl %s\n", cmd.usage)
+		return 2
+	}
+
+	method, path := cmd.request(cmdArgs)
+	body, resp, err := client.do(method, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if *jsonOutput {
+		fmt.Println(strings.TrimSpace(string(body)))
+	} else if !resp.IsSuccess {
+		fmt.Fprintf(os.Stderr, "error: %s\n", resp.Message)
+	} else if cmd.printData != nil {
+		err := cmd.printData(resp.Data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+	} else {
+		fmt.Println(resp.Message)
+	}
+	if !resp.IsSuccess {
+		return 1
+	}
+	return 0
+}
+
+type client struct {
+	httpClient *http.Client
+	baseUrl    string
+	token      string
+}
+
+func newClient(configFilePath string, configFolder string) (*client, error) {
+	cfg, err := config.ReadConfig(configFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	if !cfg.General.ControlServerEnable {
+		return nil, fmt.Errorf("control server is disabled (set `general.control_server_enable = true` in %s)", configFilePath)
+	}
+	token, err := control_api.ReadToken(cfg.General, configFolder)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		httpClient: &http.Client{},
+		token:      token,
+	}
+	switch cfg.General.ControlServerTransport {
+	case "unix":
+		socketPath := cfg.General.ControlServerSocketPath
+		if socketPath == "" {
+			socketPath = control_api.DefaultSocketPath(configFolder)
+		}
+		c.httpClient.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		}
+		c.baseUrl = "http://kanata-tray"
+	default:
+		c.baseUrl = fmt.Sprintf("http://127.0.0.1:%d", cfg.General.ControlServerPort)
+	}
+	return c, nil
+}
+
+func (c *client) newRequest(ctx context.Context, method string, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", control_api.TokenHeaderPrefix+c.token)
+	}
+	return req, nil
+}
+
+func (c *client) do(method string, path string) ([]byte, *control_api.GenericResponse[json.RawMessage], error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := c.newRequest(ctx, method, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to kanata-tray (is it running?): %v", err)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	var resp control_api.GenericResponse[json.RawMessage]
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse response (status %s): %v", httpResp.Status, err)
+	}
+	return body, &resp, nil
+}
+
+func (c *client) streamEvents(jsonOutput bool) error {
+	req, err := c.newRequest(context.Background(), http.MethodGet, "/events")
+	if err != nil {
+		return err
+	}
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to kanata-tray (is it running?): %v", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		var resp control_api.GenericResponse[json.RawMessage]
+		_ = json.NewDecoder(httpResp.Body).Decode(&resp)
+		return fmt.Errorf("request failed (status %s): %s", httpResp.Status, resp.Message)
+	}
+	scanner := bufio.NewScanner(httpResp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if jsonOutput {
+			fmt.Println(data)
+			continue
+		}
+		var event control_api.Event
+		err := json.Unmarshal([]byte(data), &event)
+		if err != nil {
+			return fmt.Errorf("failed to parse event: %v", err)
+		}
+		fmt.Println(formatEvent(event))
+	}
+	return scanner.Err()
+}
+
+func formatEvent(event control_api.Event) string {
+	prefix := fmt.Sprintf("%s [%s]", event.Time.Local().Format(time.TimeOnly), event.Preset)
+	switch event.Type {
+	case control_api.EventStatus:
+		s := fmt.Sprintf("%s status: %s", prefix, event.Status)
+		if event.Layer != "" {
+			s += fmt.Sprintf(" (layer: %s)", event.Layer)
+		}
+		if event.Error != "" {
+			s += fmt.Sprintf(" (error: %s)", event.Error)
+		}
+		return s
+	case control_api.EventLayerChange:
+		return fmt.Sprintf("%s layer: %s", prefix, event.Layer)
+	case control_api.EventLayerNames:
+		return fmt.Sprintf("%s layers: %s", prefix, strings.Join(event.Layers, ", "))
+	case control_api.EventConfigFileReload:
+		return fmt.Sprintf("%s kanata config reloaded", prefix)
+	}
+	return fmt.Sprintf("%s %s", prefix, event.Type)
+}
+
+func printTrayStatus(data json.RawMessage) error {
+	var status control_api.TrayStatus
+	err := json.Unmarshal(data, &status)
+	if err != nil {
+		return fmt.Errorf("failed to parse status: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PRESET\tSTATUS\tLAYER\tPID\tPORT\tRESTARTS\tLAST ERROR")
+	for _, p := range status.Presets {
+		pid := "-"
+		if p.Pid != 0 {
+			pid = fmt.Sprint(p.Pid)
+		}
+		lastError := p.LastError
+		if lastError == "" && len(p.Diagnostics) > 0 {
+			lastError = p.Diagnostics[0]
+		}
+		port := "auto"
+		if p.TcpPort != 0 {
+			port = fmt.Sprint(p.TcpPort)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			p.Name, p.Status, orDash(p.CurrentLayer), pid, port, p.RestartCount, orDash(firstLine(lastError)))
+	}
+	return w.Flush()
+}
+
+// Errors may contain multiple lines (e.g. hook output), which would break the table.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
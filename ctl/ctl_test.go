@@ -0,0 +1,149 @@
+package ctl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rszyma/kanata-tray/app/control_api"
+)
+
+func TestCommandRequests(t *testing.T) {
+	tests := []struct {
+		command    string
+		args       []string
+		wantMethod string
+		wantPath   string
+	}{
+		{"status", nil, http.MethodGet, "/status"},
+		{"start", []string{"main cfg"}, http.MethodPost, "/start/main%20cfg"},
+		{"stop-all", nil, http.MethodPost, "/stop_all"},
+		{"layer", []string{"main", "nav/1"}, http.MethodPost, "/presets/main/layer/nav%2F1"},
+		{"fake-key", []string{"main", "vk1", "tap"}, http.MethodPost, "/presets/main/fake_key/vk1/tap"},
+	}
+	for _, tt := range tests {
+		cmd := commands[tt.command]
+		if len(tt.args) != cmd.argsLen {
+			t.Fatalf("%s: test has %d args, command expects %d", tt.command, len(tt.args), cmd.argsLen)
+		}
+		method, path := cmd.request(tt.args)
+		if method != tt.wantMethod || path != tt.wantPath {
+			t.Errorf("%s %v = %s %s, want %s %s", tt.command, tt.args, method, path, tt.wantMethod, tt.wantPath)
+		}
+	}
+	for _, name := range commandOrder {
+		if _, ok := commands[name]; !ok && name != "events" {
+			t.Errorf("command '%s' is listed in help, but doesn't exist", name)
+		}
+	}
+}
+
+func TestFormatEvent(t *testing.T) {
+	eventTime := time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)
+	tests := []struct {
+		event control_api.Event
+		want  string
+	}{
+		{
+			control_api.Event{Type: control_api.EventStatus, Preset: "main", Time: eventTime, Status: "crashed", Error: "exit status 1"},
+			"15:04:05 [main] status: crashed (error: exit status 1)",
+		},
+		{
+			control_api.Event{Type: control_api.EventLayerChange, Preset: "main", Time: eventTime, Layer: "nav"},
+			"15:04:05 [main] layer: nav",
+		},
+		{
+			control_api.Event{Type: control_api.EventLayerNames, Preset: "main", Time: eventTime, Layers: []string{"base", "nav"}},
+			"15:04:05 [main] layers: base, nav",
+		},
+	}
+	for _, tt := range tests {
+		if got := formatEvent(tt.event); got != tt.want {
+			t.Errorf("formatEvent(%+v) = %q, want %q", tt.event, got, tt.want)
+		}
+	}
+}
+
+// Writes kanata-tray config that points ctl to the given test server.
+func writeCtlConfig(t *testing.T, srv *httptest.Server, extraGeneral string) (configFilePath string, configFolder string) {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFolder = t.TempDir()
+	configFilePath = filepath.Join(configFolder, "kanata-tray.toml")
+	content := fmt.Sprintf("[general]\ncontrol_server_enable = true\ncontrol_server_port = %s\n%s\n", u.Port(), extraGeneral)
+	if err := os.WriteFile(configFilePath, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return configFilePath, configFolder
+}
+
+func TestRun(t *testing.T) {
+	var gotMethod, gotPath, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath, gotAuth = r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization")
+		if strings.HasPrefix(r.URL.Path, "/stop/") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"IsSuccess":false,"Message":"preset with the specified name doesn't exist"}`)
+			return
+		}
+		fmt.Fprint(w, `{"IsSuccess":true,"Message":"OK"}`)
+	}))
+	defer srv.Close()
+	configFilePath, configFolder := writeCtlConfig(t, srv, "control_server_require_token = true\ncontrol_server_token = \"secret\"")
+
+	if code := Run([]string{"start", "main"}, configFilePath, configFolder); code != 0 {
+		t.Errorf("ctl start exited with %d", code)
+	}
+	if gotMethod != http.MethodPost || gotPath != "/start/main" {
+		t.Errorf("ctl start sent %s %s", gotMethod, gotPath)
+	}
+	if gotAuth != control_api.TokenHeaderPrefix+"secret" {
+		t.Errorf("ctl start sent Authorization %q", gotAuth)
+	}
+
+	if code := Run([]string{"stop", "missing"}, configFilePath, configFolder); code != 1 {
+		t.Errorf("ctl stop of a missing preset exited with %d, want 1", code)
+	}
+
+	gotPath = ""
+	if code := Run([]string{"layer", "main"}, configFilePath, configFolder); code != 2 {
+		t.Errorf("ctl layer with missing args exited with %d, want 2", code)
+	}
+	if code := Run([]string{"unknown"}, configFilePath, configFolder); code != 2 {
+		t.Errorf("ctl unknown exited with %d, want 2", code)
+	}
+	if gotPath != "" {
+		t.Errorf("invalid commands sent a request to %s", gotPath)
+	}
+}
+
+func TestRunControlServerDisabled(t *testing.T) {
+	configFolder := t.TempDir()
+	configFilePath := filepath.Join(configFolder, "kanata-tray.toml")
+	if err := os.WriteFile(configFilePath, []byte("[general]\ncontrol_server_enable = false\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code := Run([]string{"status"}, configFilePath, configFolder); code != 1 {
+		t.Errorf("ctl status with disabled control server exited with %d, want 1", code)
+	}
+}
+
+func TestRunMissingTokenFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request sent without a token: %s %s", r.Method, r.URL.Path)
+	}))
+	defer srv.Close()
+	configFilePath, configFolder := writeCtlConfig(t, srv, "control_server_require_token = true")
+	if code := Run([]string{"status"}, configFilePath, configFolder); code != 1 {
+		t.Errorf("ctl status without token file exited with %d, want 1", code)
+	}
+}
@@ -4,8 +4,10 @@ go 1.21
 
 require (
 	github.com/elliotchance/orderedmap/v2 v2.2.0
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/getlantern/systray v1.2.2
 	github.com/go-chi/chi/v5 v5.2.1
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/k0kubun/pp/v3 v3.2.0
 	github.com/kirsle/configdir v0.0.0-20170128060238-e45d2f54772f
 	github.com/kr/pretty v0.3.1
@@ -13,6 +15,10 @@ require (
 	github.com/pelletier/go-toml/v2 v2.2.0
 	github.com/skratchdot/open-golang v0.0.0-20200116055534-eef842397966
 	github.com/spf13/pflag v1.0.6
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20210519020934-456a8d69b780
+	golang.org/x/image v0.18.0
+	golang.org/x/sys v0.18.0
 )
 
 require (
@@ -37,6 +43,6 @@ require (
 	go.opentelemetry.io/otel/trace v1.24.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.27.0 // indirect
-	golang.org/x/sys v0.18.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/net v0.23.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 )
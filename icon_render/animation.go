@@ -0,0 +1,63 @@
+package icon_render
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Returns a copy of an icon with opacity of each pixel multiplied by `opacity` (0-1).
+func Fade(img image.Image, opacity float64) image.Image {
+	return fade(img, opacity)
+}
+
+func fade(img image.Image, opacity float64) *image.RGBA {
+	icon := scaleToIconSize(img)
+	// Pixels are alpha-premultiplied, so all channels are scaled.
+	for i := range icon.Pix {
+		icon.Pix[i] = uint8(float64(icon.Pix[i]) * opacity)
+	}
+	return icon
+}
+
+// Renders one frame of a spinner drawn over a faded `base` icon.
+// Spinner consists of `steps` dots placed on a circle; frame `step`
+// has the brightest dot at position `step`, followed by a fading tail.
+func RenderSpinner(base image.Image, step int, steps int) image.Image {
+	icon := fade(base, 0.5)
+	const (
+		radius    = Size * 0.34
+		dotRadius = Size * 0.08
+	)
+	center := float64(Size) / 2
+	for i := 0; i < steps; i++ {
+		angle := 2*math.Pi*float64(i)/float64(steps) - math.Pi/2
+		x := center + radius*math.Cos(angle)
+		y := center + radius*math.Sin(angle)
+		// How many steps ago the head of the spinner was at this dot.
+		age := (step - i + steps) % steps
+		opacity := 1 - float64(age)/float64(steps)
+		fillCircle(icon, x, y, dotRadius+1, color.NRGBA{A: uint8(160 * opacity)})
+		fillCircle(icon, x, y, dotRadius, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: uint8(0xff * opacity)})
+	}
+	return icon
+}
+
+// Draws an antialiased circle.
+func fillCircle(img *image.RGBA, cx, cy, r float64, c color.NRGBA) {
+	bounds := image.Rect(int(cx-r)-1, int(cy-r)-1, int(cx+r)+2, int(cy+r)+2).Intersect(img.Bounds())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dist := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			coverage := math.Max(0, math.Min(1, r+0.5-dist))
+			if coverage == 0 {
+				continue
+			}
+			pixel := c
+			pixel.A = uint8(float64(c.A) * coverage)
+			draw.Draw(img, image.Rect(x, y, x+1, y+1), image.NewUniform(pixel), image.Point{}, draw.Over)
+		}
+	}
+}
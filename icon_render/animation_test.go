@@ -0,0 +1,52 @@
+package icon_render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func solidImage(c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, Size, Size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestFade(t *testing.T) {
+	img := solidImage(color.NRGBA{R: 0xff, A: 0xff})
+	faded := Fade(img, 0.5)
+	r, g, b, a := faded.At(Size/2, Size/2).RGBA()
+	if a>>8 != 0x7f || r>>8 != 0x7f || g != 0 || b != 0 {
+		t.Errorf("faded pixel = %x %x %x %x, want half-transparent red", r>>8, g>>8, b>>8, a>>8)
+	}
+	// Original is not modified.
+	if _, _, _, a := img.At(Size/2, Size/2).RGBA(); a>>8 != 0xff {
+		t.Error("Fade modified its input")
+	}
+}
+
+func TestRenderSpinner(t *testing.T) {
+	base := solidImage(color.NRGBA{B: 0xff, A: 0xff})
+	const steps = 8
+	var frames [][]byte
+	for step := 0; step < steps; step++ {
+		frame := RenderSpinner(base, step, steps)
+		if frame.Bounds() != image.Rect(0, 0, Size, Size) {
+			t.Fatalf("frame %d bounds = %v, want %dx%d", step, frame.Bounds(), Size, Size)
+		}
+		frames = append(frames, encodePng(t, frame))
+	}
+	for i := range frames {
+		next := frames[(i+1)%steps]
+		if bytes.Equal(frames[i], next) {
+			t.Errorf("frames %d and %d are identical", i, (i+1)%steps)
+		}
+	}
+	// Spinner is drawn over a faded icon, so the background is dimmed.
+	_, _, _, a := RenderSpinner(base, 0, steps).At(Size/2, Size/2).RGBA()
+	if a>>8 == 0 || a>>8 == 0xff {
+		t.Errorf("center of spinner has alpha %x, want the icon faded", a>>8)
+	}
+}
@@ -0,0 +1,172 @@
+package icon_render
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// Height of the badge, which is drawn at the bottom of the icon.
+	badgeHeight  = Size * 9 / 16
+	badgePadding = 3
+)
+
+// Draws a badge with `text` in the bottom-right corner of `base` icon.
+// Text should be short (1-3 characters), longer text gets scaled down.
+func RenderBadge(base image.Image, text string, background color.Color) image.Image {
+	icon := scaleToIconSize(base)
+	if text == "" {
+		return icon
+	}
+
+	textImg := renderText(text, textColorFor(background))
+	textSize := textImg.Bounds().Size()
+	// Glyphs are scaled by an integer factor, so that they stay sharp.
+	scale := min((Size-2*badgePadding)/textSize.X, (badgeHeight-2*badgePadding)/textSize.Y)
+	scale = max(scale, 1)
+	scaledText := image.Rect(0, 0, textSize.X*scale, textSize.Y*scale)
+	if scaledText.Dx() > Size-2*badgePadding {
+		scaledText.Max.X = Size - 2*badgePadding
+	}
+
+	badge := image.Rect(Size-scaledText.Dx()-2*badgePadding, Size-badgeHeight, Size, Size)
+	draw.Draw(icon, badge, image.NewUniform(background), image.Point{}, draw.Src)
+	drawBorder(icon, badge, darken(background))
+
+	textRect := scaledText.Add(image.Pt(
+		badge.Min.X+badgePadding,
+		badge.Min.Y+(badge.Dy()-scaledText.Dy())/2,
+	))
+	draw.NearestNeighbor.Scale(icon, textRect, textImg, textImg.Bounds(), draw.Over, nil)
+	return icon
+}
+
+// Renders text with a bitmap font, cropped to the bounds of the glyphs.
+func renderText(text string, c color.Color) *image.RGBA {
+	face := basicfont.Face7x13
+	bounds, _ := font.BoundString(face, text)
+	rect := image.Rect(
+		bounds.Min.X.Floor(), bounds.Min.Y.Floor(),
+		bounds.Max.X.Ceil(), bounds.Max.Y.Ceil(),
+	)
+	img := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	drawer := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(-rect.Min.X, -rect.Min.Y),
+	}
+	drawer.DrawString(text)
+	return img
+}
+
+func drawBorder(img *image.RGBA, r image.Rectangle, c color.Color) {
+	for x := r.Min.X; x < r.Max.X; x++ {
+		img.Set(x, r.Min.Y, c)
+		img.Set(x, r.Max.Y-1, c)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		img.Set(r.Min.X, y, c)
+		img.Set(r.Max.X-1, y, c)
+	}
+}
+
+// Returns black or white, whichever is more readable on `background`.
+func textColorFor(background color.Color) color.Color {
+	r, g, b, _ := background.RGBA()
+	luminance := 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+	if luminance > 0.6*0xffff {
+		return color.Black
+	}
+	return color.White
+}
+
+func darken(c color.Color) color.Color {
+	r, g, b, a := c.RGBA()
+	return color.RGBA64{R: uint16(r * 2 / 3), G: uint16(g * 2 / 3), B: uint16(b * 2 / 3), A: uint16(a)}
+}
+
+// Returns up to 2 uppercase characters identifying a layer, e.g.
+// "nav" -> "NA", "nav-layer" -> "NL", "symbolsLeft" -> "SL", "fn2" -> "F2".
+func Initials(layerName string) string {
+	var words [][]rune
+	var current []rune
+	for _, r := range layerName {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			if len(current) > 0 {
+				words = append(words, current)
+				current = nil
+			}
+			continue
+		case len(current) > 0 && (unicode.IsUpper(r) && unicode.IsLower(current[len(current)-1]) ||
+			unicode.IsDigit(r) != unicode.IsDigit(current[len(current)-1])):
+			// camelCase or letters followed by digits
+			words = append(words, current)
+			current = nil
+		}
+		current = append(current, r)
+	}
+	if len(current) > 0 {
+		words = append(words, current)
+	}
+
+	var initials []rune
+	switch len(words) {
+	case 0:
+		return ""
+	case 1:
+		initials = words[0][:min(2, len(words[0]))]
+	default:
+		initials = []rune{words[0][0], words[1][0]}
+	}
+	return strings.ToUpper(string(initials))
+}
+
+// Returns a color derived from the layer name, so that each layer
+// has a distinct, but stable color.
+func DefaultBadgeColor(layerName string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(layerName))
+	hue := float64(h.Sum32()%360) / 60
+	// HSV to RGB with saturation 0.7 and value 0.8
+	const value, saturation = 0.8, 0.7
+	chroma := value * saturation
+	x := chroma * (1 - abs(mod2(hue)-1))
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g, b = chroma, x, 0
+	case 1:
+		r, g, b = x, chroma, 0
+	case 2:
+		r, g, b = 0, chroma, x
+	case 3:
+		r, g, b = 0, x, chroma
+	case 4:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	m := value - chroma
+	return color.RGBA{R: uint8((r + m) * 255), G: uint8((g + m) * 255), B: uint8((b + m) * 255), A: 0xff}
+}
+
+func mod2(f float64) float64 {
+	return f - 2*float64(int(f/2))
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
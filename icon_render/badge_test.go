@@ -0,0 +1,56 @@
+package icon_render
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestInitials(t *testing.T) {
+	tests := []struct {
+		layerName string
+		want      string
+	}{
+		{"nav", "NA"},
+		{"nav-layer", "NL"},
+		{"symbolsLeft", "SL"},
+		{"fn2", "F2"},
+		{"x", "X"},
+		{"base_qwerty layer", "BQ"},
+		{"łódź", "ŁÓ"},
+		{"--", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Initials(tt.layerName); got != tt.want {
+			t.Errorf("Initials(%q) = %q, want %q", tt.layerName, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultBadgeColor(t *testing.T) {
+	if DefaultBadgeColor("nav") != DefaultBadgeColor("nav") {
+		t.Error("DefaultBadgeColor is not stable")
+	}
+	if DefaultBadgeColor("nav") == DefaultBadgeColor("sym") {
+		t.Error("DefaultBadgeColor returned the same color for different layers")
+	}
+	if c := DefaultBadgeColor("nav"); c.A != 0xff {
+		t.Errorf("DefaultBadgeColor is not opaque: %v", c)
+	}
+}
+
+func TestRenderBadge(t *testing.T) {
+	base := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	img := RenderBadge(base, "NA", color.RGBA{R: 0xff, A: 0xff})
+	if got := img.Bounds(); got != image.Rect(0, 0, Size, Size) {
+		t.Errorf("RenderBadge bounds = %v, want %dx%d", got, Size, Size)
+	}
+	// Base image is fully transparent, so the badge must be the only thing drawn.
+	if _, _, _, a := img.At(Size-2, Size-2).RGBA(); a == 0 {
+		t.Error("badge is not drawn in the bottom right corner")
+	}
+	if _, _, _, a := img.At(1, 1).RGBA(); a != 0 {
+		t.Error("badge covers the top left corner")
+	}
+}
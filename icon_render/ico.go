@@ -0,0 +1,177 @@
+package icon_render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// ICO file format: https://en.wikipedia.org/wiki/ICO_(file_format)
+
+const (
+	icoHeaderSize     = 6
+	icoDirEntrySize   = 16
+	bmpInfoHeaderSize = 40
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func isIco(data []byte) bool {
+	return len(data) >= icoHeaderSize && bytes.Equal(data[:4], []byte{0, 0, 1, 0})
+}
+
+// Decodes the largest image in an ICO file. Images can be stored either
+// as PNG or as BMP (with 1, 4, 8, 24 or 32 bits per pixel).
+func decodeIco(data []byte) (image.Image, error) {
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if count == 0 {
+		return nil, fmt.Errorf("ico: no images")
+	}
+	if len(data) < icoHeaderSize+count*icoDirEntrySize {
+		return nil, fmt.Errorf("ico: truncated directory")
+	}
+	var best []byte
+	bestSize := -1
+	for i := 0; i < count; i++ {
+		entry := data[icoHeaderSize+i*icoDirEntrySize:]
+		size := int(entry[0])
+		if size == 0 {
+			size = 256
+		}
+		length := binary.LittleEndian.Uint32(entry[8:12])
+		offset := binary.LittleEndian.Uint32(entry[12:16])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("ico: image %d is out of bounds", i)
+		}
+		if size > bestSize {
+			best = data[offset : offset+length]
+			bestSize = size
+		}
+	}
+	if bytes.HasPrefix(best, pngSignature) {
+		return png.Decode(bytes.NewReader(best))
+	}
+	return decodeIcoBmp(best)
+}
+
+// Decodes BMP image stored in ICO file: BITMAPINFOHEADER (with doubled height),
+// optional palette, bottom-up pixel rows and 1-bit transparency mask.
+func decodeIcoBmp(data []byte) (image.Image, error) {
+	if len(data) < bmpInfoHeaderSize {
+		return nil, fmt.Errorf("ico: truncated bitmap header")
+	}
+	le := binary.LittleEndian
+	headerSize := int(le.Uint32(data[0:4]))
+	width := int(int32(le.Uint32(data[4:8])))
+	height := int(int32(le.Uint32(data[8:12]))) / 2
+	bitCount := int(le.Uint16(data[14:16]))
+	compression := le.Uint32(data[16:20])
+	colorsUsed := int(le.Uint32(data[32:36]))
+	if compression != 0 {
+		return nil, fmt.Errorf("ico: compressed bitmaps are not supported")
+	}
+	if width <= 0 || height <= 0 || width > 1024 || height > 1024 {
+		return nil, fmt.Errorf("ico: invalid bitmap size %dx%d", width, height)
+	}
+
+	pos := headerSize
+	var palette []color.RGBA
+	switch bitCount {
+	case 1, 4, 8:
+		if colorsUsed == 0 {
+			colorsUsed = 1 << bitCount
+		}
+		if len(data) < pos+colorsUsed*4 {
+			return nil, fmt.Errorf("ico: truncated palette")
+		}
+		for i := 0; i < colorsUsed; i++ {
+			c := data[pos+i*4:]
+			palette = append(palette, color.RGBA{R: c[2], G: c[1], B: c[0], A: 0xff})
+		}
+		pos += colorsUsed * 4
+	case 24, 32:
+	default:
+		return nil, fmt.Errorf("ico: unsupported bit count %d", bitCount)
+	}
+
+	stride := (width*bitCount + 31) / 32 * 4
+	maskStride := (width + 31) / 32 * 4
+	maskPos := pos + stride*height
+	hasMask := len(data) >= maskPos+maskStride*height
+	if len(data) < maskPos {
+		return nil, fmt.Errorf("ico: truncated bitmap")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	anyAlpha := false
+	for y := 0; y < height; y++ {
+		row := data[pos+(height-1-y)*stride:]
+		for x := 0; x < width; x++ {
+			var c color.RGBA
+			switch bitCount {
+			case 32:
+				c = color.RGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+				anyAlpha = anyAlpha || c.A != 0
+			case 24:
+				c = color.RGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 0xff}
+			default:
+				pixelsPerByte := 8 / bitCount
+				b := row[x/pixelsPerByte]
+				shift := uint(8 - bitCount*(x%pixelsPerByte+1))
+				index := int(b>>shift) & (1<<bitCount - 1)
+				if index < len(palette) {
+					c = palette[index]
+				}
+			}
+			img.SetNRGBA(x, y, color.NRGBA(c))
+		}
+	}
+	// 32-bit images use alpha channel, unless it's empty. Otherwise
+	// the mask decides which pixels are transparent.
+	if (bitCount != 32 || !anyAlpha) && hasMask {
+		for y := 0; y < height; y++ {
+			row := data[maskPos+(height-1-y)*maskStride:]
+			for x := 0; x < width; x++ {
+				transparent := row[x/8]&(0x80>>uint(x%8)) != 0
+				c := img.NRGBAAt(x, y)
+				if transparent {
+					c.A = 0
+				} else {
+					c.A = 0xff
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+	return img, nil
+}
+
+// Encodes an image as ICO file with a single PNG-compressed image
+// (supported since Windows Vista).
+func encodeIco(img image.Image) ([]byte, error) {
+	var pngData bytes.Buffer
+	err := png.Encode(&pngData, img)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() > 256 || bounds.Dy() > 256 {
+		return nil, fmt.Errorf("ico: image is too big: %dx%d", bounds.Dx(), bounds.Dy())
+	}
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&buf, le, [3]uint16{0, 1, 1}) // reserved, type (icon), image count
+	buf.WriteByte(byte(bounds.Dx()))           // 256 is stored as 0
+	buf.WriteByte(byte(bounds.Dy()))
+	buf.WriteByte(0)                   // palette size
+	buf.WriteByte(0)                   // reserved
+	binary.Write(&buf, le, uint16(1))  // color planes
+	binary.Write(&buf, le, uint16(32)) // bits per pixel
+	binary.Write(&buf, le, uint32(pngData.Len()))
+	binary.Write(&buf, le, uint32(icoHeaderSize+icoDirEntrySize))
+	buf.Write(pngData.Bytes())
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,71 @@
+package icon_render
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeIcoBmp(t *testing.T) {
+	// Status icons are stored as BMP images with 4, 24 and 32 bits per pixel.
+	for _, name := range []string{"default.ico", "crash.ico", "pause.ico"} {
+		data, err := os.ReadFile(filepath.Join("..", "status_icons", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isIco(data) {
+			t.Fatalf("%s: not recognized as ICO", name)
+		}
+		img, err := decodeIco(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := img.Bounds(); got != image.Rect(0, 0, 128, 128) {
+			t.Errorf("%s: bounds = %v, want 128x128", name, got)
+		}
+	}
+}
+
+func TestIcoRoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	red := color.RGBA{R: 0xff, A: 0xff}
+	img.Set(3, 5, red)
+	data, err := encodeIco(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Bounds(); got != img.Bounds() {
+		t.Fatalf("bounds = %v, want %v", got, img.Bounds())
+	}
+	if got := color.RGBAModel.Convert(decoded.At(3, 5)); got != red {
+		t.Errorf("pixel (3,5) = %v, want %v", got, red)
+	}
+	if _, _, _, a := decoded.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("pixel (0,0) is not transparent")
+	}
+}
+
+func TestEncodeIcoTooBig(t *testing.T) {
+	if _, err := encodeIco(image.NewRGBA(image.Rect(0, 0, 257, 16))); err == nil {
+		t.Error("encodeIco succeeded for an image wider than 256px")
+	}
+}
+
+func TestDecodeIcoMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"no images":         {0, 0, 1, 0, 0, 0},
+		"truncated":         {0, 0, 1, 0, 2, 0, 16, 16},
+		"image out of file": append([]byte{0, 0, 1, 0, 1, 0, 16, 16, 0, 0, 1, 0, 32, 0, 0xff, 0, 0, 0, 22, 0, 0, 0}, make([]byte, 8)...),
+	}
+	for name, data := range tests {
+		if _, err := decodeIco(data); err == nil {
+			t.Errorf("%s: decodeIco succeeded, want an error", name)
+		}
+	}
+}
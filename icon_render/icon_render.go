@@ -0,0 +1,127 @@
+// Package icon_render decodes tray icons and renders generated icons
+// (e.g. a base icon with a text badge) in a format accepted by systray
+// on the current platform.
+package icon_render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Width and height of rendered icons.
+const Size = 64
+
+// Decodes an icon in ICO, PNG, JPEG or SVG format.
+func Decode(data []byte) (image.Image, error) {
+	switch {
+	case isIco(data):
+		return decodeIco(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return png.Decode(bytes.NewReader(data))
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return jpeg.Decode(bytes.NewReader(data))
+	case isSvg(data):
+		return decodeSvg(data)
+	}
+	return nil, fmt.Errorf("unsupported icon format, expected ICO, PNG, JPEG or SVG")
+}
+
+// Encodes an image in the format expected by systray on the current
+// platform: ICO on Windows, PNG elsewhere.
+func Encode(img image.Image) ([]byte, error) {
+	if runtime.GOOS == "windows" {
+		return encodeIco(img)
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type cacheKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+var (
+	cacheMu sync.Mutex
+	// Converted icons. A file is converted again if it has been modified.
+	cache = map[cacheKey][]byte{}
+)
+
+// Reads an icon file, scales it to tray icon size and converts it to
+// the format expected by systray (see `Encode`). Results are cached.
+func LoadFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	key := cacheKey{path: path, size: info.Size(), modTime: info.ModTime()}
+	cacheMu.Lock()
+	data, ok := cache[key]
+	cacheMu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := Decode(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode '%s': %v", path, err)
+	}
+	data, err = Encode(scaleToIconSize(img))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode '%s': %v", path, err)
+	}
+
+	cacheMu.Lock()
+	cache[key] = data
+	cacheMu.Unlock()
+	return data, nil
+}
+
+// Scales an image to fit in `Size`x`Size`, keeping its aspect ratio.
+func scaleToIconSize(img image.Image) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, Size, Size))
+	drawFitted(result, result.Bounds(), img)
+	return result
+}
+
+// Draws `img` scaled to fit in `rect`, centered and keeping its aspect ratio.
+func drawFitted(dst *image.RGBA, rect image.Rectangle, img image.Image) {
+	src := img.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	if src.Dx()*h > src.Dy()*w {
+		h = w * src.Dy() / src.Dx()
+	} else {
+		w = h * src.Dx() / src.Dy()
+	}
+	x := rect.Min.X + (rect.Dx()-w)/2
+	y := rect.Min.Y + (rect.Dy()-h)/2
+	draw.CatmullRom.Scale(dst, image.Rect(x, y, x+w, y+h), img, src, draw.Over, nil)
+}
+
+// Places icons side by side, each scaled to fit in an equal part of the icon.
+func Composite(icons []image.Image) image.Image {
+	result := image.NewRGBA(image.Rect(0, 0, Size, Size))
+	for i, icon := range icons {
+		cell := image.Rect(Size*i/len(icons), 0, Size*(i+1)/len(icons), Size)
+		drawFitted(result, cell, icon)
+	}
+	return result
+}
@@ -0,0 +1,110 @@
+package icon_render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testSvg = `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 10">
+  <rect x="0" y="0" width="20" height="10" fill="#ff0000"/>
+</svg>
+`
+
+func encodePng(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeSvg(t *testing.T) {
+	for _, data := range []string{testSvg, "\xef\xbb\xbf  " + testSvg} {
+		img, err := Decode([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.Bounds(); got != image.Rect(0, 0, Size, Size) {
+			t.Fatalf("bounds = %v, want %dx%d", got, Size, Size)
+		}
+		// 2:1 view box is centered vertically.
+		if _, _, _, a := img.At(Size/2, Size/2).RGBA(); a == 0 {
+			t.Error("center pixel is transparent")
+		}
+		if _, _, _, a := img.At(Size/2, 2).RGBA(); a != 0 {
+			t.Error("pixel above the image is not transparent")
+		}
+	}
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	for _, data := range []string{"", "GIF89a", "<html></html>"} {
+		if _, err := Decode([]byte(data)); err == nil {
+			t.Errorf("Decode(%q) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	src := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for x := 0; x < 32; x++ {
+		for y := 0; y < 16; y++ {
+			src.Set(x, y, color.RGBA{B: 0xff, A: 0xff})
+		}
+	}
+	pngPath := filepath.Join(dir, "icon.png")
+	if err := os.WriteFile(pngPath, encodePng(t, src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := LoadFile(pngPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(data)
+	if err != nil {
+		t.Fatalf("LoadFile result can't be decoded: %v", err)
+	}
+	if got := img.Bounds(); got != image.Rect(0, 0, Size, Size) {
+		t.Errorf("bounds = %v, want %dx%d", got, Size, Size)
+	}
+
+	// Modified file is converted again instead of being served from cache.
+	if err := os.WriteFile(pngPath, []byte(testSvg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(pngPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	svgData, err := LoadFile(pngPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(svgData, data) {
+		t.Error("LoadFile returned a stale cached icon after the file was modified")
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadFile(filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("LoadFile of a missing file succeeded")
+	}
+	path := filepath.Join(dir, "icon.txt")
+	if err := os.WriteFile(path, []byte("not an icon"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("LoadFile of an unsupported file succeeded")
+	}
+}
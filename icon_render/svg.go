@@ -0,0 +1,38 @@
+package icon_render
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+func isSvg(data []byte) bool {
+	head := data[:min(len(data), 512)]
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+	head = bytes.TrimLeft(head, " \t\r\n")
+	return bytes.HasPrefix(head, []byte("<svg")) ||
+		(bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<!--"))) && bytes.Contains(head, []byte("<svg"))
+}
+
+// Rasterizes SVG image to `Size`x`Size`, keeping its aspect ratio.
+func decodeSvg(data []byte) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	w, h := float64(Size), float64(Size)
+	if vb := icon.ViewBox; vb.W > 0 && vb.H > 0 {
+		if vb.W > vb.H {
+			h = Size * vb.H / vb.W
+		} else {
+			w = Size * vb.W / vb.H
+		}
+	}
+	icon.SetTarget((Size-w)/2, (Size-h)/2, w, h)
+	img := image.NewRGBA(image.Rect(0, 0, Size, Size))
+	scanner := rasterx.NewScannerGV(Size, Size, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(Size, Size, scanner), 1)
+	return img, nil
+}
@@ -0,0 +1,171 @@
+// Package logging configures gommon log output format and adds logging
+// of records with structured fields (e.g. preset name, hook number),
+// which are rendered as JSON object keys when JSON format is used.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/labstack/gommon/log"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Structured fields of a log record.
+type Fields map[string]any
+
+// Returns a copy of fields with added key-value pairs.
+func (f Fields) With(keysAndValues ...any) Fields {
+	result := make(Fields, len(f)+len(keysAndValues)/2)
+	for k, v := range f {
+		result[k] = v
+	}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		result[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	return result
+}
+
+var (
+	// Separate logger is used for structured records, because header of
+	// the global one would report this package as the caller.
+	structured = log.New("-")
+	jsonFormat atomic.Bool
+	withCaller atomic.Bool
+)
+
+// Sets log level and format of both global gommon logger and structured records.
+func Setup(format string, level log.Lvl) error {
+	switch format {
+	case FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("invalid log format '%s', expected '%s' or '%s'", format, FormatText, FormatJSON)
+	}
+	log.SetLevel(level)
+	structured.SetLevel(level)
+	jsonFormat.Store(format == FormatJSON)
+	withCaller.Store(level <= log.DEBUG)
+
+	if format == FormatJSON {
+		log.DisableColor()
+		structured.DisableColor()
+		if level <= log.DEBUG {
+			log.SetHeader(`{"time":"${time_rfc3339_nano}","level":"${level}","file":"${short_file}","line":"${line}"}`)
+		} else {
+			log.SetHeader(`{"time":"${time_rfc3339_nano}","level":"${level}"}`)
+		}
+		structured.SetHeader(`{"time":"${time_rfc3339_nano}","level":"${level}"}`)
+		return nil
+	}
+	if level <= log.DEBUG {
+		log.SetHeader(`${time_rfc3339_nano} ${level} ${short_file}:${line}`)
+	} else {
+		log.SetHeader(`${time_rfc3339_nano} ${level}`)
+	}
+	structured.SetHeader(`${time_rfc3339_nano} ${level}`)
+	return nil
+}
+
+func SetOutput(w io.Writer) {
+	log.SetOutput(w)
+	structured.SetOutput(w)
+	if jsonFormat.Load() {
+		// SetOutput re-enables color when writing to a terminal.
+		log.DisableColor()
+		structured.DisableColor()
+	}
+}
+
+func Debugf(fields Fields, format string, args ...any) {
+	write(log.DEBUG, fields, format, args)
+}
+
+func Infof(fields Fields, format string, args ...any) {
+	write(log.INFO, fields, format, args)
+}
+
+func Warnf(fields Fields, format string, args ...any) {
+	write(log.WARN, fields, format, args)
+}
+
+func Errorf(fields Fields, format string, args ...any) {
+	write(log.ERROR, fields, format, args)
+}
+
+func write(level log.Lvl, fields Fields, format string, args []any) {
+	if level < structured.Level() {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	file, line := "", 0
+	if withCaller.Load() {
+		// 0 = write, 1 = Infof etc., 2 = caller
+		_, file, line, _ = runtime.Caller(2)
+		file = path.Base(file)
+	}
+
+	if jsonFormat.Load() {
+		j := make(log.JSON, len(fields)+3)
+		for k, v := range fields {
+			if err, ok := v.(error); ok {
+				// errors are usually marshalled as empty objects
+				v = err.Error()
+			}
+			j[k] = v
+		}
+		if file != "" {
+			j["file"] = file
+			j["line"] = strconv.Itoa(line)
+		}
+		j["message"] = message
+		switch level {
+		case log.DEBUG:
+			structured.Debugj(j)
+		case log.INFO:
+			structured.Infoj(j)
+		case log.WARN:
+			structured.Warnj(j)
+		default:
+			structured.Errorj(j)
+		}
+		return
+	}
+
+	var sb strings.Builder
+	if file != "" {
+		fmt.Fprintf(&sb, "%s:%d ", file, line)
+	}
+	sb.WriteString(message)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fmt.Sprint(fields[k])
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&sb, " %s=%s", k, v)
+	}
+	switch level {
+	case log.DEBUG:
+		structured.Debug(sb.String())
+	case log.INFO:
+		structured.Info(sb.String())
+	case log.WARN:
+		structured.Warn(sb.String())
+	default:
+		structured.Error(sb.String())
+	}
+}
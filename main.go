@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/getlantern/systray"
 	"github.com/kirsle/configdir"
@@ -15,8 +19,12 @@ import (
 	"github.com/spf13/pflag"
 
 	app_pkg "github.com/rszyma/kanata-tray/app"
+	"github.com/rszyma/kanata-tray/app/control_api"
 	"github.com/rszyma/kanata-tray/app/controlserver"
+	"github.com/rszyma/kanata-tray/app/single_instance"
 	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/ctl"
+	"github.com/rszyma/kanata-tray/logging"
 	runner_pkg "github.com/rszyma/kanata-tray/runner"
 	"github.com/rszyma/kanata-tray/status_icons"
 )
@@ -28,9 +36,14 @@ var (
 )
 
 var (
-	logLevel = pflag.Uint("log-level", uint(log.INFO), "Set log level for kanata-tray (1-debug, 2-info, 3-warn) (NOTE: doesn't affect kanata logging level).")
-	version  = pflag.Bool("version", false, "Print the version and exit.")
-	help     = pflag.Bool("help", false, "Print help and exit.")
+	logLevel  = pflag.Uint("log-level", uint(log.INFO), "Set log level for kanata-tray (1-debug, 2-info, 3-warn) (NOTE: doesn't affect kanata logging level).")
+	logFormat = pflag.String("log-format", "", "Set log format for kanata-tray (text, json). Overrides general.log_format config option.")
+	version   = pflag.Bool("version", false, "Print the version and exit.")
+	help      = pflag.Bool("help", false, "Print help and exit.")
+
+	checkConfig = pflag.Bool("check-config", false, "Check config and kanata configs of all presets (with kanata --check), print found problems and exit.")
+
+	startPresets = pflag.StringArray("start-preset", nil, "Start a preset (can be used multiple times). If kanata-tray is already running, the running instance will start it.")
 )
 
 const (
@@ -39,6 +52,9 @@ const (
 )
 
 const additional_help = `
+Commands:
+      ctl - control already running kanata-tray (see: kanata-tray ctl --help)
+
 Environment Variables:
       KANATA_TRAY_CONFIG_DIR - sets custom config directory
       KANATA_TRAY_LOG_DIR - sets custom log directory (default is same folder as the binary)
@@ -46,6 +62,11 @@ Environment Variables:
 `
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		configFolder := figureOutConfigDir()
+		os.Exit(ctl.Run(os.Args[2:], filepath.Join(configFolder, configFileName), configFolder))
+	}
+
 	pflag.Parse()
 
 	if *help {
@@ -67,9 +88,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *checkConfig {
+		os.Exit(runConfigCheck())
+	}
+
 	err := mainImpl()
 	if err != nil {
-		log.Errorf("kanata-tray exited with an error: %v", err)
+		logging.Errorf(nil, "kanata-tray exited with an error: %v", err)
 		os.Exit(1)
 	}
 }
@@ -84,7 +109,7 @@ func figureOutConfigDir() string {
 	}
 	exePath, err := exePath()
 	if err != nil {
-		log.Errorf("Failed to get kanata-tray executable path, can't check if kanata-tray.toml is there. Error: %v", err)
+		logging.Errorf(nil, "Failed to get kanata-tray executable path, can't check if kanata-tray.toml is there. Error: %v", err)
 	} else {
 		exeDir := filepath.Dir(exePath)
 		if _, err := os.Stat(filepath.Join(exeDir, configFileName)); !os.IsNotExist(err) {
@@ -107,12 +132,34 @@ func exePath() (string, error) {
 }
 
 func mainImpl() error {
-	log.SetLevel(log.Lvl(*logLevel))
+	// Until config is read, the format from the flag (or text) is used.
+	initialLogFormat := *logFormat
+	if initialLogFormat == "" {
+		initialLogFormat = logging.FormatText
+	}
+	err := logging.Setup(initialLogFormat, log.Lvl(*logLevel))
+	if err != nil {
+		return err
+	}
 
-	if int(*logLevel) <= int(log.DEBUG) {
-		log.SetHeader(`${time_rfc3339_nano} ${level} ${short_file}:${line}`)
-	} else {
-		log.SetHeader(`${time_rfc3339_nano} ${level}`)
+	configFolder := figureOutConfigDir()
+
+	// Create <configFolder> and <configFolder>/icons if needed.
+	err = os.MkdirAll(filepath.Join(configFolder, "icons"), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	// This must happen before creating log file, which would otherwise
+	// truncate the log file of the running instance.
+	instance, err := single_instance.Acquire(configFolder)
+	if errors.Is(err, single_instance.ErrAlreadyRunning) {
+		logging.Infof(nil, "kanata-tray is already running, handing off to the running instance")
+		return single_instance.SendHandoff(configFolder, single_instance.Handoff{
+			StartPresets: *startPresets,
+		})
+	} else if err != nil {
+		logging.Warnf(nil, "Failed to ensure that only one kanata-tray instance is running: %v", err)
 	}
 
 	var logDir string
@@ -140,38 +187,39 @@ func mainImpl() error {
 	// Windows binary compiled with -H=windowsgui ldflag.
 	_, err = os.Stderr.Stat()
 	if err != nil {
-		log.SetOutput(logFile)
+		logging.SetOutput(logFile)
 	} else {
 		// FIXME: logger lib disables color output for tee here
 		// because it detects it's not directly a tty.
-		log.SetOutput(io.MultiWriter(logFile, os.Stderr))
+		logging.SetOutput(io.MultiWriter(logFile, os.Stderr))
 	}
 
-	log.Infof("kanata-tray [version=%s, commit=%s, build_date=%s] starting", buildVersion, buildHash, buildDate)
+	logging.Infof(nil, "kanata-tray [version=%s, commit=%s, build_date=%s] starting", buildVersion, buildHash, buildDate)
 
-	configFolder := figureOutConfigDir()
-	log.Infof("kanata-tray config folder: %s", configFolder)
-	log.Infof("kanata-tray log folder: %s", logDir)
-
-	// Create <configFolder> and <configFolder>/icons if needed.
-	err = os.MkdirAll(filepath.Join(configFolder, "icons"), os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
-	}
+	logging.Infof(nil, "kanata-tray config folder: %s", configFolder)
+	logging.Infof(nil, "kanata-tray log folder: %s", logDir)
 
 	err = os.Chdir(configFolder)
 	if err != nil {
 		return fmt.Errorf("failed to change directory: %v", err)
 	}
 
-	cfg, err := config.ReadConfigOrCreateIfNotExist(filepath.Join(configFolder, configFileName))
+	configFilePath := filepath.Join(configFolder, configFileName)
+	cfg, err := config.ReadConfigOrCreateIfNotExist(configFilePath)
 	if err != nil {
 		return fmt.Errorf("ReadConfigOrCreateIfNotExist failed: %v", err)
 	}
+	if *logFormat == "" && cfg.General.LogFormat != initialLogFormat {
+		err := logging.Setup(cfg.General.LogFormat, log.Lvl(*logLevel))
+		if err != nil {
+			return err
+		}
+	}
 	menuTemplate, err := app_pkg.MenuTemplateFromConfig(*cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create menu from config: %v", err)
 	}
+	app_pkg.ValidatePresets(menuTemplate, cfg.General.AllowConcurrentPresets, configFolder)
 	layerIcons := app_pkg.ResolveIcons(configFolder, cfg)
 
 	err = status_icons.CreateDefaultStatusIconsDirIfNotExists(configFolder)
@@ -190,18 +238,74 @@ func mainImpl() error {
 		LayerIcons:             layerIcons,
 		AllowConcurrentPresets: cfg.General.AllowConcurrentPresets,
 		LogFilepath:            logFilepath,
+		KanataLogsDir:          filepath.Join(logDir, app_pkg.KanataLogsDirName),
+		KanataLogMaxSize:       cfg.General.KanataLogMaxSize,
+		KanataLogMaxFiles:      cfg.General.KanataLogMaxFiles,
+		Notify:                 notifyOptions(cfg.General),
+		IconAnimations:         iconAnimationOptions(cfg.General),
+		IconPolicy:             cfg.General.IconPolicy,
+		Tooltip:                cfg.General.Tooltip,
+		MenuTitle:              cfg.General.MenuTitle,
+		Runner:                 runner,
 	})
 
+	// Stops live-reloading config once the tray is exiting.
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+
 	onReady := func() {
 		app.InitSystray()
-		go app.StartProcessingLoop(runner, configFolder)
+		go app.StartProcessingLoop(configFolder)
 		if cfg.General.ControlServerEnable {
 			go func() {
-				err = controlserver.RunControlServer(app, cfg.General.ControlServerPort)
-				log.Errorf("app.RunControlServer failed: %v", err)
+				socketPath := cfg.General.ControlServerSocketPath
+				if socketPath == "" {
+					socketPath = control_api.DefaultSocketPath(configFolder)
+				}
+				token, err := controlserver.ResolveToken(cfg.General, configFolder)
+				if err != nil {
+					logging.Errorf(nil, "Failed to set up control server token, not starting control server: %v", err)
+					return
+				}
+				err = controlserver.RunControlServer(app, controlserver.ServerOpts{
+					Transport:  cfg.General.ControlServerTransport,
+					Port:       cfg.General.ControlServerPort,
+					SocketPath: socketPath,
+					SocketMode: cfg.General.ControlServerSocketMode,
+					Token:      token,
+				})
+				logging.Errorf(nil, "app.RunControlServer failed: %v", err)
 			}()
 		}
 		app.Autorun()
+		for _, presetName := range *startPresets {
+			err := app.StartPreset(presetName)
+			if err != nil {
+				logging.Errorf(logging.Fields{"preset": presetName}, "Failed to start preset '%s': %v", presetName, err)
+			}
+		}
+		if instance != nil {
+			go func() {
+				err := instance.ListenForHandoffs(func(handoff single_instance.Handoff) error {
+					for _, presetName := range handoff.StartPresets {
+						err := app.StartPreset(presetName)
+						if err != nil {
+							return fmt.Errorf("failed to start preset '%s': %v", presetName, err)
+						}
+					}
+					return nil
+				})
+				logging.Errorf(nil, "Listening for other kanata-tray instances failed: %v", err)
+			}()
+		}
+		go func() {
+			err := config.WatchConfigFile(watchCtx, configFilePath, func() {
+				reloadConfig(app, configFolder, configFilePath)
+			})
+			if err != nil {
+				logging.Errorf(nil, "config.WatchConfigFile failed, live-reloading config is disabled: %v", err)
+			}
+		}()
 	}
 
 	sigCh := make(chan os.Signal, 10)
@@ -209,12 +313,130 @@ func mainImpl() error {
 
 	go func() {
 		sig := <-sigCh
-		log.Infof("Received exit signal (%s)", sig)
+		logging.Infof(nil, "Received exit signal (%s)", sig)
+		stopWatching()
 		app.Cleanup()
 		os.Exit(1)
 	}()
 
-	systray.Run(onReady, nil)
+	systray.Run(onReady, stopWatching)
 
 	return nil
 }
+
+func reloadConfig(app *app_pkg.SystrayApp, configFolder string, configFilePath string) {
+	// The file may be briefly missing while an editor replaces it.
+	// Don't let ReadConfigOrCreateIfNotExist overwrite it with the default config.
+	if _, err := os.Stat(configFilePath); err != nil {
+		logging.Debugf(nil, "config file is not accessible, skipping reload: %v", err)
+		return
+	}
+	logging.Infof(nil, "Config file changed, reloading")
+	cfg, err := config.ReadConfigOrCreateIfNotExist(configFilePath)
+	if err != nil {
+		logging.Errorf(nil, "Failed to reload config, keeping the previous one: %v", err)
+		return
+	}
+	menuTemplate, err := app_pkg.MenuTemplateFromConfig(*cfg)
+	if err != nil {
+		logging.Errorf(nil, "Failed to create menu from reloaded config, keeping the previous one: %v", err)
+		return
+	}
+	app_pkg.ValidatePresets(menuTemplate, cfg.General.AllowConcurrentPresets, configFolder)
+	app.ReloadConfig(app_pkg.Opts{
+		MenuTemplate:           menuTemplate,
+		LayerIcons:             app_pkg.ResolveIcons(configFolder, cfg),
+		AllowConcurrentPresets: cfg.General.AllowConcurrentPresets,
+		KanataLogMaxSize:       cfg.General.KanataLogMaxSize,
+		KanataLogMaxFiles:      cfg.General.KanataLogMaxFiles,
+		Notify:                 notifyOptions(cfg.General),
+		IconAnimations:         iconAnimationOptions(cfg.General),
+		IconPolicy:             cfg.General.IconPolicy,
+		Tooltip:                cfg.General.Tooltip,
+		MenuTitle:              cfg.General.MenuTitle,
+	})
+}
+
+// Validates config and prints found problems. Returns exit code:
+// 0 if no errors were found (warnings are allowed), 1 otherwise.
+func runConfigCheck() int {
+	configFolder := figureOutConfigDir()
+	configFilePath := filepath.Join(configFolder, configFileName)
+	fmt.Printf("Checking %s\n", configFilePath)
+	if _, err := os.Stat(configFilePath); err != nil {
+		fmt.Printf("error: config file can't be accessed: %v\n", err)
+		return 1
+	}
+	err := os.Chdir(configFolder)
+	if err != nil {
+		fmt.Printf("error: failed to change directory: %v\n", err)
+		return 1
+	}
+	cfg, err := config.ReadConfig(configFilePath)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 1
+	}
+	menuTemplate, err := app_pkg.MenuTemplateFromConfig(*cfg)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 1
+	}
+	app_pkg.ValidatePresets(menuTemplate, cfg.General.AllowConcurrentPresets, configFolder)
+
+	anyErrors := false
+	if diags := app_pkg.ValidateDefaultLayerIcons(cfg, configFolder); len(diags) > 0 {
+		fmt.Println()
+		fmt.Println("defaults:")
+		for _, d := range diags {
+			fmt.Printf("  %s\n", d)
+		}
+	}
+	for _, entry := range menuTemplate {
+		fmt.Println()
+		fmt.Printf("preset '%s':\n", entry.PresetName)
+		for _, d := range entry.Diagnostics {
+			fmt.Printf("  %s\n", d)
+		}
+		if !entry.IsSelectable {
+			anyErrors = true
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		output, err := runner_pkg.CheckKanataConfig(ctx, entry.Preset.KanataExecutable, entry.Preset.KanataConfig)
+		cancel()
+		if err != nil {
+			anyErrors = true
+			fmt.Printf("  error: %v\n", err)
+			for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+			continue
+		}
+		fmt.Println("  kanata config OK")
+	}
+
+	fmt.Println()
+	if anyErrors {
+		fmt.Println("Config check failed")
+		return 1
+	}
+	fmt.Println("Config check passed")
+	return 0
+}
+
+func notifyOptions(general config.GeneralConfigOptions) app_pkg.NotifyOptions {
+	return app_pkg.NotifyOptions{
+		Crash:         general.NotifyOnCrash,
+		RestartGiveUp: general.NotifyOnRestartGiveUp,
+		HookFailure:   general.NotifyOnHookFailure,
+		LayerChange:   general.NotifyOnLayerChange,
+	}
+}
+
+func iconAnimationOptions(general config.GeneralConfigOptions) app_pkg.IconAnimationOptions {
+	return app_pkg.IconAnimationOptions{
+		StatusAnimations:   general.AnimateStatusIcons,
+		LayerChangeFlashes: general.LayerChangeFlashes,
+	}
+}
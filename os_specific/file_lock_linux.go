@@ -0,0 +1,20 @@
+package os_specific
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Returned by TryLockFile when the file is locked by another process.
+var ErrLocked = errors.New("file is locked by another process")
+
+// Tries to acquire an exclusive lock on the file without blocking.
+// The lock is released when the file is closed or the process exits.
+func TryLockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
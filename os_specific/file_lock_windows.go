@@ -0,0 +1,25 @@
+package os_specific
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Returned by TryLockFile when the file is locked by another process.
+var ErrLocked = errors.New("file is locked by another process")
+
+// Tries to acquire an exclusive lock on the file without blocking.
+// The lock is released when the file is closed or the process exits.
+func TryLockFile(f *os.File) error {
+	err := windows.LockFileEx(
+		windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{},
+	)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,23 @@
+package os_specific
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Returned by CheckPrivateFile when users other than the owner can access the file.
+var ErrFileAccessibleByOthers = errors.New("file is accessible by other users")
+
+// Checks that the file is owned by the current user and that no other user
+// can access it.
+func CheckPrivateFile(info os.FileInfo) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("file is owned by another user (uid %d)", stat.Uid)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return ErrFileAccessibleByOthers
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package os_specific
+
+import (
+	"os"
+	"syscall"
+)
+
+// Runs `fn` with the given permission bits added to process umask, so that
+// files created by `fn` never have them. Umask is process-wide, so it also
+// applies to files created concurrently by other goroutines. Umask is never
+// loosened while swapping it, so those files can only get more restrictive
+// permissions.
+func WithUmask(mask os.FileMode, fn func()) {
+	old := syscall.Umask(0o777)
+	syscall.Umask(old | int(mask.Perm()))
+	defer syscall.Umask(old)
+	fn()
+}
@@ -0,0 +1,43 @@
+//go:build linux || darwin
+
+package os_specific
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func currentUmask() int {
+	mask := syscall.Umask(0o777)
+	syscall.Umask(mask)
+	return mask
+}
+
+func TestWithUmask(t *testing.T) {
+	before := currentUmask()
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "test.sock")
+	var listener net.Listener
+	var err error
+	WithUmask(^os.FileMode(0o600), func() {
+		listener, err = net.Listen("unix", socketPath)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		t.Errorf("socket permissions = %o, want no group or other permissions", perm)
+	}
+
+	if after := currentUmask(); after != before {
+		t.Errorf("umask after WithUmask = %o, want %o", after, before)
+	}
+}
@@ -0,0 +1,8 @@
+package os_specific
+
+import "os"
+
+// There's no umask on Windows, so `fn` is just called.
+func WithUmask(mask os.FileMode, fn func()) {
+	fn()
+}
@@ -0,0 +1,42 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// Returns path to kanata executable. If `kanataExecutable` is empty,
+// kanata is searched for in PATH.
+func ResolveKanataExecutable(kanataExecutable string) (string, error) {
+	if kanataExecutable == "" {
+		// FIXME: kanata.exe on Windows?
+		kanataExecutable = "kanata"
+	}
+	path, err := exec.LookPath(kanataExecutable)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Runs `kanata --check` to validate kanata config file without starting kanata.
+// Returns combined stdout and stderr of kanata, and an error if the check failed.
+func CheckKanataConfig(ctx context.Context, kanataExecutable string, kanataConfig string) (string, error) {
+	kanataExecutable, err := ResolveKanataExecutable(kanataExecutable)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"--check"}
+	if kanataConfig != "" {
+		args = append(args, "-c", kanataConfig)
+	}
+	var output bytes.Buffer
+	cmd := cmd(ctx, &output, &output, kanataExecutable, args...)
+	err = cmd.Run()
+	if err != nil {
+		return output.String(), fmt.Errorf("kanata --check failed: %v", err)
+	}
+	return output.String(), nil
+}
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/logging"
+	"github.com/rszyma/kanata-tray/runner/tcp_client"
+)
+
+// Maximum number of events waiting for their hooks to run.
+// Events over the limit are dropped.
+const eventHooksQueueSize = 32
+
+// Hooks that are run on events reported by kanata via TCP.
+type eventHooks struct {
+	info           hookInfo
+	sequential     bool
+	onLayerChange  []config.Hook
+	onConfigReload []config.Hook
+}
+
+// Queues hooks for the event in the message (if any) to be run. Doesn't block.
+// Hook failures are only logged, they don't stop the preset.
+func (r *Kanata) queueEventHooks(msg tcp_client.ServerMessage) {
+	h := r.eventHooks.Load()
+	if h == nil {
+		return
+	}
+	var hooks []config.Hook
+	var hookType string
+	var info hookInfo
+	switch {
+	case msg.LayerChange != nil && len(h.onLayerChange) > 0:
+		hooks, hookType = h.onLayerChange, "on-layer-change"
+		info = h.info.withEnv("KANATA_TRAY_LAYER=" + msg.LayerChange.NewLayer)
+	case msg.ConfigFileReload != nil && len(h.onConfigReload) > 0:
+		hooks, hookType = h.onConfigReload, "on-config-reload"
+		info = h.info.withEnv("KANATA_TRAY_RELOADED_CONFIG=" + msg.ConfigFileReload.New)
+	default:
+		return
+	}
+	run := func() {
+		err := runAllBlockingHooks(info, hooks, h.sequential, hookType)
+		if err != nil {
+			logging.Errorf(logging.Fields{"preset": info.presetName}, "%s hook failed: %v", hookType, err)
+		}
+	}
+	select {
+	case r.eventHooksQueue <- run:
+	default:
+		logging.Warnf(logging.Fields{"preset": info.presetName}, "Too many queued hooks, dropping %s hooks for an event", hookType)
+	}
+}
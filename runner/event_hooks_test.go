@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/runner/tcp_client"
+)
+
+// Returns a hook that writes value of an environment variable to a file.
+func envDumpHook(t *testing.T, name string) (config.Hook, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	path := filepath.Join(t.TempDir(), name)
+	return config.Hook{Args: []string{"/bin/sh", "-c", `printf %s "$` + name + `" > "$0"`, path}}, path
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestQueueEventHooks(t *testing.T) {
+	hook, path := envDumpHook(t, "KANATA_TRAY_LAYER")
+	r := &Kanata{eventHooksQueue: make(chan func(), eventHooksQueueSize)}
+
+	// No hooks are queued while kanata isn't running.
+	r.queueEventHooks(tcp_client.ServerMessage{LayerChange: &tcp_client.LayerChange{NewLayer: "nav"}})
+	if len(r.eventHooksQueue) != 0 {
+		t.Fatal("hooks were queued while kanata isn't running")
+	}
+
+	r.eventHooks.Store(&eventHooks{
+		info:          hookInfo{presetName: "test", output: NewHookOutput(presetHookOutputLines)},
+		onLayerChange: []config.Hook{hook},
+	})
+	// There are no on-config-reload hooks.
+	r.queueEventHooks(tcp_client.ServerMessage{ConfigFileReload: &tcp_client.ConfigFileReload{New: "a.kbd"}})
+	r.queueEventHooks(tcp_client.ServerMessage{LayerNames: &tcp_client.LayerNames{Names: []string{"base"}}})
+	if len(r.eventHooksQueue) != 0 {
+		t.Fatal("hooks were queued for events without hooks")
+	}
+
+	r.queueEventHooks(tcp_client.ServerMessage{LayerChange: &tcp_client.LayerChange{NewLayer: "nav"}})
+	if len(r.eventHooksQueue) != 1 {
+		t.Fatalf("%d hook runs were queued, want 1", len(r.eventHooksQueue))
+	}
+	(<-r.eventHooksQueue)()
+	if got := readFile(t, path); got != "nav" {
+		t.Errorf("on-layer-change hook got KANATA_TRAY_LAYER=%q, want %q", got, "nav")
+	}
+
+	// Events over the queue limit are dropped instead of blocking.
+	for i := 0; i < eventHooksQueueSize+1; i++ {
+		r.queueEventHooks(tcp_client.ServerMessage{LayerChange: &tcp_client.LayerChange{NewLayer: "nav"}})
+	}
+	if len(r.eventHooksQueue) != eventHooksQueueSize {
+		t.Errorf("%d hook runs were queued, want %d", len(r.eventHooksQueue), eventHooksQueueSize)
+	}
+}
@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of most recent lines of hook output kept per preset.
+	presetHookOutputLines = 1000
+	// Number of last lines of output of a failed hook, that are included in the error.
+	failedHookOutputLines = 10
+)
+
+// Keeps most recent lines of output of hooks. Safe for concurrent use.
+type HookOutput struct {
+	mu       sync.Mutex
+	lines    []string
+	next     int // index in `lines` where the next line will be written once full
+	maxLines int
+}
+
+func NewHookOutput(maxLines int) *HookOutput {
+	return &HookOutput{maxLines: maxLines}
+}
+
+func (h *HookOutput) add(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.lines) < h.maxLines {
+		h.lines = append(h.lines, line)
+		return
+	}
+	h.lines[h.next] = line
+	h.next = (h.next + 1) % h.maxLines
+}
+
+// Returns kept lines, oldest first.
+func (h *HookOutput) Lines() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]string, 0, len(h.lines))
+	result = append(result, h.lines[h.next:]...)
+	result = append(result, h.lines[:h.next]...)
+	return result
+}
+
+func (h *HookOutput) String() string {
+	lines := h.Lines()
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Records output of a single hook run: to its own tail (used in error message)
+// and to preset hook output (with timestamp and hook identification).
+type hookOutputRecorder struct {
+	presetOutput *HookOutput
+	tail         *HookOutput
+	prefix       string
+}
+
+func newHookOutputRecorder(presetOutput *HookOutput, hookType string, hookNum int32) *hookOutputRecorder {
+	return &hookOutputRecorder{
+		presetOutput: presetOutput,
+		tail:         NewHookOutput(failedHookOutputLines),
+		prefix:       fmt.Sprintf("[%s hook %d]", hookType, hookNum),
+	}
+}
+
+// Adds a line describing what happened with the hook (e.g. it was started),
+// that isn't part of the hook's output.
+func (r *hookOutputRecorder) note(format string, args ...any) {
+	r.presetOutput.add(fmt.Sprintf("%s %s %s", time.Now().Format(time.DateTime), r.prefix, fmt.Sprintf(format, args...)))
+}
+
+func (r *hookOutputRecorder) addOutputLine(stream string, line string) {
+	r.tail.add(line)
+	r.presetOutput.add(fmt.Sprintf("%s %s [%s] %s", time.Now().Format(time.DateTime), r.prefix, stream, line))
+}
+
+// Wraps hook error with the last lines of hook output (if any).
+func (r *hookOutputRecorder) wrapErr(err error) error {
+	lines := r.tail.Lines()
+	if len(lines) == 0 {
+		return err
+	}
+	return fmt.Errorf("%v\nlast lines of hook output:\n  %s", err, strings.Join(lines, "\n  "))
+}
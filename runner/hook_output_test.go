@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHookOutputKeepsMostRecentLines(t *testing.T) {
+	h := NewHookOutput(3)
+	if got := h.String(); got != "" {
+		t.Errorf("String() of empty output = %q, want \"\"", got)
+	}
+	for i := 1; i <= 2; i++ {
+		h.add(fmt.Sprint(i))
+	}
+	if got, want := h.Lines(), []string{"1", "2"}; !slices.Equal(got, want) {
+		t.Errorf("Lines() = %v, want %v", got, want)
+	}
+	for i := 3; i <= 7; i++ {
+		h.add(fmt.Sprint(i))
+	}
+	if got, want := h.Lines(), []string{"5", "6", "7"}; !slices.Equal(got, want) {
+		t.Errorf("Lines() = %v, want %v", got, want)
+	}
+	if got, want := h.String(), "5\n6\n7\n"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestHookOutputRecorder(t *testing.T) {
+	presetOutput := NewHookOutput(presetHookOutputLines)
+	r := newHookOutputRecorder(presetOutput, "pre-start", 2)
+	r.note("started")
+	for i := 1; i <= failedHookOutputLines+2; i++ {
+		r.addOutputLine("stdout", fmt.Sprintf("line %d", i))
+	}
+
+	lines := presetOutput.Lines()
+	if len(lines) != failedHookOutputLines+3 {
+		t.Fatalf("preset output has %d lines, want %d", len(lines), failedHookOutputLines+3)
+	}
+	if !strings.HasSuffix(lines[0], "[pre-start hook 2] started") {
+		t.Errorf("note line = %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "[pre-start hook 2] [stdout] line 1") {
+		t.Errorf("output line = %q", lines[1])
+	}
+
+	msg := r.wrapErr(errors.New("exit status 1")).Error()
+	if !strings.HasPrefix(msg, "exit status 1\n") {
+		t.Errorf("wrapped error doesn't start with the original error: %q", msg)
+	}
+	if strings.Contains(msg, "line 2\n") || !strings.Contains(msg, "line 3\n") || !strings.HasSuffix(msg, "line 12") {
+		t.Errorf("wrapped error should contain only the last %d lines: %q", failedHookOutputLines, msg)
+	}
+}
+
+func TestHookOutputRecorderNoOutput(t *testing.T) {
+	r := newHookOutputRecorder(NewHookOutput(presetHookOutputLines), "post-stop", 1)
+	err := errors.New("exit status 1")
+	if got := r.wrapErr(err); got != err {
+		t.Errorf("wrapErr without output = %v, want the original error", got)
+	}
+}
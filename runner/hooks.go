@@ -4,62 +4,137 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
+	"os/exec"
 	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/labstack/gommon/log"
+	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/logging"
 )
 
 var hookNum atomic.Int32
 
-// Runs all hooks at the same time, blocking waiting for all of them to finish,
-// or they get killed after short timeout.
+// Information about preset, which hooks are run for.
+type hookInfo struct {
+	presetName string
+	// Output of all hooks of the preset is recorded here.
+	output *HookOutput
+	// Environment variables describing the preset and the event
+	// that hooks are run for, in "key=value" form.
+	env []string
+}
+
+// Returns a copy of hook info with added environment variables.
+func (h hookInfo) withEnv(vars ...string) hookInfo {
+	h.env = append(slices.Clone(h.env), vars...)
+	return h
+}
+
+// Returned (and sent to `RetCh`) when a preset was stopped because of a failed hook.
+type HookError struct {
+	HookType string // e.g. "pre-start"
+	Err      error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %v", e.HookType, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// Default maximum runtime of a blocking hook.
+const defaultBlockingHookTimeout = 5 * time.Second
+
+// Runs blocking hooks and waits for them to finish. Hooks are run in groups
+// by their order (lower first). Hooks within a group run at the same time,
+// unless `sequential` is set, in which case every hook is in its own group.
+// Hooks get killed after their timeout.
 //
-// Returns first encountered error within all hook errors.
+// Returns first encountered error within all hook errors. Hooks from
+// groups after the group that failed are not run.
 //
 // `hookType` - stringified hook type e.g. "pre-start".
-func runAllBlockingHooks(hooks [][]string, hookType string) error {
-	timeout := 5 * time.Second
-	// We don't use ctx from outside, because we want to guarantee
-	// that the hooks finish normally in case of cancel from outside
-	// (e.g. when rapidly switching presets)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+func runAllBlockingHooks(info hookInfo, hooks []config.Hook, sequential bool, hookType string) error {
+	for _, group := range groupHooks(hooks, sequential) {
+		err := runBlockingHookGroup(info, group, hookType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Splits hooks into groups of hooks with the same order, sorted by order.
+// Hooks keep the order of declaration within a group.
+func groupHooks(hooks []config.Hook, sequential bool) [][]config.Hook {
+	sorted := slices.Clone(hooks)
+	slices.SortStableFunc(sorted, func(a, b config.Hook) int {
+		return a.Order - b.Order
+	})
+	var groups [][]config.Hook
+	for i, hook := range sorted {
+		if sequential || i == 0 || sorted[i-1].Order != hook.Order {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], hook)
+	}
+	return groups
+}
+
+// Runs all hooks at the same time, blocking waiting for all of them to finish.
+func runBlockingHookGroup(info hookInfo, hooks []config.Hook, hookType string) error {
 	wg := sync.WaitGroup{}
 	wg.Add(len(hooks))
 	errors := make([]error, len(hooks))
-	for _, hook := range hooks {
+	for i, hook := range hooks {
 		n := hookNum.Add(1)
-		log.Infof("Running %s hook [%d] '%#v'", hookType, n, hook)
-		hook := slices.Clone(hook)
+		logFields := hookLogFields(info.presetName, n, hookType)
+		logging.Infof(logFields.With("event", "hook_started"), "Running %s hook [%d] '%#v'", hookType, n, hook.Args)
+		recorder := newHookOutputRecorder(info.output, hookType, n)
+		recorder.note("running %#v", hook.Args)
+		i := i
+		hook := hook
 		go func() {
 			defer wg.Done()
-			cmd := cmd(
-				ctx,
-				makeLogWrapWriter(fmt.Sprintf("hook=%d", n), "&1"),
-				makeLogWrapWriter(fmt.Sprintf("hook=%d", n), "&2"),
-				hook[0],
-				hook[1:]...,
-			)
-			// TODO: capture stdout/stderr?
-			err := cmd.Start()
-			if err != nil {
-				errors[n] = fmt.Errorf("failed to run %s hook [%d]: %v", hookType, n, err)
-				return
+			timeout := hook.Timeout
+			if timeout == 0 {
+				timeout = defaultBlockingHookTimeout
 			}
-			err = cmd.Wait()
-			if err != nil {
+			// We don't use ctx from outside, because we want to guarantee
+			// that the hooks finish normally in case of cancel from outside
+			// (e.g. when rapidly switching presets)
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			cmd := hookCmd(ctx, info, hook, hookType, logFields, recorder)
+			var err error
+			if err = cmd.Start(); err != nil {
+				recorder.note("failed to run: %v", err)
+				err = fmt.Errorf("failed to run %s hook [%d]: %v", hookType, n, err)
+			} else if err = cmd.Wait(); err != nil {
 				if ctxErr := ctx.Err(); ctxErr != nil && ctxErr == context.DeadlineExceeded {
-					errors[n] = fmt.Errorf("hook [%d] was killed because it exceeded maximum allowed runtime for non-async hooks (%s)", n, timeout)
+					recorder.note("killed after exceeding maximum allowed runtime (%s)", timeout)
+					err = recorder.wrapErr(fmt.Errorf("hook [%d] was killed because it exceeded maximum allowed runtime (%s)", n, timeout))
 				} else {
-					errors[n] = fmt.Errorf("hook [%d] failed with an error: %v", n, err)
+					recorder.note("failed: %v", err)
+					err = recorder.wrapErr(fmt.Errorf("hook [%d] failed with an error: %v", n, err))
 				}
+			}
+			if err != nil {
+				if hook.AllowFailure {
+					logging.Warnf(logFields.With("event", "hook_failed"), "Ignoring failure of %s hook [%d] (allow_failure is set): %v", hookType, n, err)
+					return
+				}
+				errors[i] = err
 				return
 			}
-			log.Infof("%s [%d] exited OK", hookType, n)
+			recorder.note("exited OK")
+			logging.Infof(logFields.With("event", "hook_exited"), "%s [%d] exited OK", hookType, n)
 		}()
 	}
 	wg.Wait()
@@ -71,64 +146,111 @@ func runAllBlockingHooks(hooks [][]string, hookType string) error {
 	return nil
 }
 
+// All hooks are started at the same time. Hook order is not taken into account.
+//
 // `hookType` - stringified hook type e.g. "pre-start".
 //
 // Returns an error if any error ocurred during startup of any hook.
-func runAllAsyncHooks(ctx context.Context, hooks [][]string, hookType string, anyHookErroredCh chan<- error, allHooksExitedCh chan<- struct{}) error {
-	anyHookErrored := false
+func runAllAsyncHooks(ctx context.Context, info hookInfo, hooks []config.Hook, hookType string, anyHookErroredCh chan<- error, allHooksExitedCh chan<- struct{}) error {
+	var anyHookErrored atomic.Bool
 	wg := sync.WaitGroup{}
 	wg.Add(len(hooks))
 	go func() {
 		wg.Wait()
 		allHooksExitedCh <- struct{}{}
 	}()
-	for _, hook := range hooks {
+	for i, hook := range hooks {
 		n := hookNum.Add(1)
-		log.Infof("Running %s hook [%d] '%#v'", hookType, n, hook)
-		hook := slices.Clone(hook) // fix race condition
-		cmd := cmd(
-			ctx,
-			makeLogWrapWriter(fmt.Sprintf("hook=%d", n), "&1"),
-			makeLogWrapWriter(fmt.Sprintf("hook=%d", n), "&2"),
-			hook[0],
-			hook[1:]...,
-		)
-		// TODO: capture stdout/stderr?
+		logFields := hookLogFields(info.presetName, n, hookType)
+		logging.Infof(logFields.With("event", "hook_started"), "Running %s hook [%d] '%#v'", hookType, n, hook.Args)
+		recorder := newHookOutputRecorder(info.output, hookType, n)
+		recorder.note("running %#v", hook.Args)
+		hookCtx, cancel := ctx, context.CancelFunc(func() {})
+		if hook.Timeout != 0 {
+			hookCtx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		}
+		cmd := hookCmd(hookCtx, info, hook, hookType, logFields, recorder)
 		err := cmd.Start()
 		if err != nil {
-			log.Errorf("Failed to run %s hook [%d]: %v", hookType, n, err)
+			cancel()
+			recorder.note("failed to run: %v", err)
+			logging.Errorf(logFields, "Failed to run %s hook [%d]: %v", hookType, n, err)
+			if hook.AllowFailure {
+				wg.Done()
+				continue
+			}
+			// this hook and the following ones will never run
+			wg.Add(-(len(hooks) - i))
 			return err
 		}
+		hook := hook
 		go func() {
 			defer wg.Done()
+			defer cancel()
 			err := cmd.Wait()
 			if err != nil {
 				if ctxErr := ctx.Err(); ctxErr != nil {
-					log.Warnf("hook [%d] was killed because of cancel signal: %v", n, ctxErr)
+					recorder.note("killed because of cancel signal: %v", ctxErr)
+					logging.Warnf(logFields.With("event", "hook_killed"), "hook [%d] was killed because of cancel signal: %v", n, ctxErr)
+					return
+				}
+				if hookCtx.Err() == context.DeadlineExceeded {
+					recorder.note("killed after exceeding maximum allowed runtime (%s)", hook.Timeout)
+					err = recorder.wrapErr(fmt.Errorf("hook [%d] was killed because it exceeded maximum allowed runtime (%s)", n, hook.Timeout))
 				} else {
-					log.Errorf("Hook [%d] failed with an error: %v", n, err)
+					recorder.note("failed: %v", err)
+					err = recorder.wrapErr(fmt.Errorf("hook [%d] failed with an error: %v", n, err))
+				}
+				if hook.AllowFailure {
+					logging.Warnf(logFields.With("event", "hook_failed"), "Ignoring failure of %s hook [%d] (allow_failure is set): %v", hookType, n, err)
+					return
 				}
-				if !anyHookErrored {
-					anyHookErrored = true
+				logging.Errorf(logFields.With("event", "hook_failed"), "%v", err)
+				if anyHookErrored.CompareAndSwap(false, true) {
 					anyHookErroredCh <- err
 				}
 				return
 			}
-			log.Infof("%s [%d] exited OK", hookType, n)
+			recorder.note("exited OK")
+			logging.Infof(logFields.With("event", "hook_exited"), "%s [%d] exited OK", hookType, n)
 		}()
 	}
 	return nil
 }
 
-func makeLogWrapWriter(prefixes ...string) io.Writer {
-	var prefixesFormatted string
-	for _, prefix := range prefixes {
-		prefixesFormatted = fmt.Sprintf("%s[%s]", prefixesFormatted, prefix)
-	}
+func hookCmd(ctx context.Context, info hookInfo, hook config.Hook, hookType string,
+	logFields logging.Fields, recorder *hookOutputRecorder,
+) *exec.Cmd {
+	cmd := cmd(
+		ctx,
+		makeHookOutputWriter(logFields, recorder, "stdout"),
+		makeHookOutputWriter(logFields, recorder, "stderr"),
+		hook.Args[0],
+		hook.Args[1:]...,
+	)
+	// Variables set in hook config take precedence.
+	cmd.Env = append(os.Environ(), info.env...)
+	cmd.Env = append(cmd.Env, "KANATA_TRAY_HOOK_TYPE="+hookType)
+	cmd.Env = append(cmd.Env, hook.Env...)
+	cmd.Dir = hook.Cwd
+	return cmd
+}
+
+func hookLogFields(presetName string, hookNum int32, hookType string) logging.Fields {
+	return logging.Fields{"preset": presetName, "hook": hookNum, "hook_type": hookType}
+}
+
+// Records each line written to the returned writer and logs it as a separate debug record.
+// `stream` - "stdout" or "stderr".
+func makeHookOutputWriter(fields logging.Fields, recorder *hookOutputRecorder, stream string) io.Writer {
+	fields = fields.With("event", "hook_output", "stream", stream)
 	return &writerFunc{func(p []byte) (int, error) {
-		s := strings.Trim(string(p), "\n")
-		if len(s) > 0 {
-			log.Debugf("%s %s", prefixesFormatted, s)
+		for _, line := range strings.Split(strings.Trim(string(p), "\n"), "\n") {
+			line = strings.TrimRight(line, "\r")
+			if len(line) > 0 {
+				recorder.addOutputLine(stream, line)
+				logging.Debugf(fields, "%s", line)
+			}
 		}
 		return len(p), nil
 	}}
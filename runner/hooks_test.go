@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rszyma/kanata-tray/config"
+)
+
+func hookNames(groups [][]config.Hook) [][]string {
+	var result [][]string
+	for _, group := range groups {
+		var names []string
+		for _, hook := range group {
+			names = append(names, hook.Args[0])
+		}
+		result = append(result, names)
+	}
+	return result
+}
+
+func TestGroupHooks(t *testing.T) {
+	hooks := []config.Hook{
+		{Args: []string{"a"}, Order: 1},
+		{Args: []string{"b"}},
+		{Args: []string{"c"}, Order: 1},
+		{Args: []string{"d"}, Order: -1},
+		{Args: []string{"e"}},
+	}
+	tests := []struct {
+		sequential bool
+		want       [][]string
+	}{
+		{false, [][]string{{"d"}, {"b", "e"}, {"a", "c"}}},
+		{true, [][]string{{"d"}, {"b"}, {"e"}, {"a"}, {"c"}}},
+	}
+	for _, tt := range tests {
+		got := hookNames(groupHooks(hooks, tt.sequential))
+		if !slices.EqualFunc(got, tt.want, slices.Equal[[]string]) {
+			t.Errorf("groupHooks(sequential=%t) = %v, want %v", tt.sequential, got, tt.want)
+		}
+	}
+	if hooks[0].Args[0] != "a" {
+		t.Error("groupHooks modified its input")
+	}
+}
+
+func TestGroupHooksEmpty(t *testing.T) {
+	if got := groupHooks(nil, false); len(got) != 0 {
+		t.Errorf("groupHooks(nil) = %v, want no groups", got)
+	}
+}
+
+func TestRunAllAsyncHooksFailures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	info := hookInfo{presetName: "test", output: NewHookOutput(presetHookOutputLines)}
+	failing := config.Hook{Args: []string{"/bin/sh", "-c", "exit 1"}}
+	anyHookErroredCh := make(chan error, 1)
+	allHooksExitedCh := make(chan struct{}, 1)
+	err := runAllAsyncHooks(context.Background(), info, []config.Hook{failing, failing, failing}, "post-start-async", anyHookErroredCh, allHooksExitedCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-allHooksExitedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hooks didn't exit")
+	}
+	select {
+	case err := <-anyHookErroredCh:
+		if err == nil {
+			t.Error("got nil error from a failed hook")
+		}
+	default:
+		t.Error("failure of hooks was not reported")
+	}
+}
+
+func TestRunAllAsyncHooksStartFailure(t *testing.T) {
+	info := hookInfo{presetName: "test", output: NewHookOutput(presetHookOutputLines)}
+	missing := config.Hook{Args: []string{"kanata-tray-missing-program"}}
+	allHooksExitedCh := make(chan struct{}, 1)
+	err := runAllAsyncHooks(context.Background(), info, []config.Hook{missing, missing}, "post-start-async", make(chan error, 1), allHooksExitedCh)
+	if err == nil {
+		t.Fatal("runAllAsyncHooks succeeded with a missing program")
+	}
+	select {
+	case <-allHooksExitedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("allHooksExitedCh not signalled after a hook failed to start")
+	}
+}
+
+func TestHookCmdEnv(t *testing.T) {
+	base := hookInfo{presetName: "main", env: []string{"KANATA_TRAY_PRESET=main", "KANATA_TRAY_TCP_PORT=5829"}}
+	info := base.withEnv("KANATA_TRAY_LAYER=nav")
+	if len(base.env) != 2 {
+		t.Errorf("withEnv modified the original hook info: %v", base.env)
+	}
+	hook := config.Hook{Args: []string{"true"}, Env: []string{"KANATA_TRAY_LAYER=overridden"}}
+	cmd := hookCmd(context.Background(), info, hook, "on-layer-change", nil, nil)
+
+	// Later entries take precedence when the command is run.
+	env := map[string]string{}
+	for _, kv := range cmd.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+	want := map[string]string{
+		"KANATA_TRAY_PRESET":    "main",
+		"KANATA_TRAY_TCP_PORT":  "5829",
+		"KANATA_TRAY_HOOK_TYPE": "on-layer-change",
+		"KANATA_TRAY_LAYER":     "overridden",
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s=%q, want %q", k, env[k], v)
+		}
+	}
+	if os.Getenv("PATH") != "" && env["PATH"] == "" {
+		t.Error("environment of kanata-tray is not passed to hooks")
+	}
+}
@@ -2,14 +2,16 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"net"
 	"os/exec"
+	"sync/atomic"
 	"time"
 
-	"github.com/labstack/gommon/log"
-
 	"github.com/rszyma/kanata-tray/config"
+	"github.com/rszyma/kanata-tray/logging"
 	"github.com/rszyma/kanata-tray/runner/tcp_client"
 )
 
@@ -21,33 +23,50 @@ type Kanata struct {
 	// This must be written to, to free an internal slot.
 	processSlotCh chan struct{}
 
-	retCh     chan error // Returns the error returned by `cmd.Wait()`
+	retCh     chan error    // Returns the error returned by `cmd.Wait()`
+	readyCh   chan struct{} // Written to when kanata has opened its TCP port
 	cmd       *exec.Cmd
+	pid       atomic.Int64 // 0 if kanata process is not running
+	tcpPort   atomic.Int64 // port of the current or the last run
 	tcpClient *tcp_client.KanataTcpClient
+
+	// Hooks to run on events reported by kanata. nil while kanata is not ready.
+	eventHooks atomic.Pointer[eventHooks]
+	// Event hooks are queued and run one at a time, so that hooks
+	// of the same type see events in the order they happened.
+	eventHooksQueue chan func()
 }
 
 func NewKanata() *Kanata {
-	return &Kanata{
+	r := &Kanata{
 		processSlotCh: make(chan struct{}, 1),
 
 		retCh:     make(chan error),
+		readyCh:   make(chan struct{}),
 		cmd:       nil,
 		tcpClient: tcp_client.NewTcpClient(),
+
+		eventHooksQueue: make(chan func(), eventHooksQueueSize),
 	}
+	go func() {
+		for run := range r.eventHooksQueue {
+			run()
+		}
+	}()
+	return r
 }
 
-func (r *Kanata) RunNonblocking(ctx context.Context, kanataExecutable string, kanataConfig string,
-	tcpPort int, hooks config.Hooks, extraArgs []string, logFile *os.File,
+func (r *Kanata) RunNonblocking(ctx context.Context, presetName string, kanataExecutable string, kanataConfig string,
+	tcpPort int, hooks config.Hooks, extraArgs []string, logFile io.Writer, startupTimeout time.Duration,
+	hookOutput *HookOutput,
 ) error {
-	if kanataExecutable == "" {
-		var err error
-		// FIXME: kanata.exe on Windows?
-		kanataExecutable, err = exec.LookPath("kanata")
-		if err != nil {
-			return err
-		}
+	kanataExecutable, err := ResolveKanataExecutable(kanataExecutable)
+	if err != nil {
+		return err
 	}
 
+	r.tcpPort.Store(int64(tcpPort))
+
 	allArgs := []string{}
 
 	if kanataConfig != "" {
@@ -58,9 +77,24 @@ func (r *Kanata) RunNonblocking(ctx context.Context, kanataExecutable string, ka
 
 	allArgs = append(allArgs, extraArgs...)
 
-	cmd := cmd(ctx, nil, nil, kanataExecutable, allArgs...)
+	// Kanata is killed by cancelling `killCtx` instead of `ctx`,
+	// because pre-stop hooks need to run while kanata is still alive.
+	killCtx, kill := context.WithCancel(context.Background())
+	cmd := cmd(killCtx, nil, nil, kanataExecutable, allArgs...)
+
+	logFields := logging.Fields{"preset": presetName}
+	hookInfo := hookInfo{
+		presetName: presetName,
+		output:     hookOutput,
+		env: []string{
+			"KANATA_TRAY_PRESET=" + presetName,
+			"KANATA_TRAY_KANATA_CONFIG=" + kanataConfig,
+			fmt.Sprintf("KANATA_TRAY_TCP_PORT=%d", tcpPort),
+		},
+	}
 
 	go func() {
+		defer kill()
 		selfCtx, selfCancel := context.WithCancelCause(ctx)
 		defer selfCancel(nil)
 
@@ -77,13 +111,19 @@ func (r *Kanata) RunNonblocking(ctx context.Context, kanataExecutable string, ka
 		r.cmd.Stdout = logFile
 		r.cmd.Stderr = logFile
 
-		err = runAllBlockingHooks(hooks.PreStart, "pre-start")
+		err = runAllBlockingHooks(hookInfo, hooks.PreStart, hooks.Sequential, "pre-start")
 		if err != nil {
-			r.retCh <- fmt.Errorf("runAllBlockingHooks: %s", err)
+			r.retCh <- &HookError{HookType: "pre-start", Err: err}
+			return
+		}
+		if selfCtx.Err() != nil {
+			// stopped while pre-start hooks were running
+			r.cmd = nil
+			r.retCh <- nil
 			return
 		}
 
-		log.Infof("Running command: %s", r.cmd.String())
+		logging.Infof(logFields, "Running command: %s", r.cmd.String())
 
 		err = r.cmd.Start()
 		if err != nil {
@@ -91,22 +131,97 @@ func (r *Kanata) RunNonblocking(ctx context.Context, kanataExecutable string, ka
 			return
 		}
 
-		log.Infof("Started kanata (pid=%d)", r.cmd.Process.Pid)
+		pid := r.cmd.Process.Pid
+		logFields = logging.Fields{"preset": presetName, "pid": pid}
+		logging.Infof(logFields.With("event", "kanata_started"), "Started kanata")
+		r.pid.Store(int64(pid))
+		hookInfo = hookInfo.withEnv(fmt.Sprintf("KANATA_TRAY_KANATA_PID=%d", pid))
 
-		// Need to wait until kanata boot up and setups the TCP server.
-		// 2000 ms is a default start delay in kanata.
-		time.Sleep(time.Millisecond * 2500)
+		var cmdErr error // valid after `processExited` is closed
+		processExited := make(chan struct{})
+		go func() {
+			cmdErr = cmd.Wait()
+			close(processExited)
+		}()
+
+		// Run pre-stop hooks and kill kanata, when preset is stopped
+		// while kanata is still running.
+		go func() {
+			select {
+			case <-processExited:
+			case <-selfCtx.Done():
+				// Both channels may be ready at once (e.g. selfCtx is cancelled
+				// when kanata exits), and select picks one at random.
+				select {
+				case <-processExited:
+					return
+				default:
+				}
+				err := runAllBlockingHooks(hookInfo, hooks.PreStop, hooks.Sequential, "pre-stop")
+				if err != nil {
+					logging.Errorf(logFields, "pre-stop hook failed, stopping kanata anyway: %v", err)
+				}
+				kill()
+			}
+		}()
 
-		err = runAllBlockingHooks(hooks.PostStart, "post-start")
+		// Need to wait until kanata boots up and sets up the TCP server.
+		err = waitForTcpPort(selfCtx, tcpPort, startupTimeout, processExited)
 		if err != nil {
-			r.retCh <- fmt.Errorf("runAllBlockingHooks: %s", err)
+			if selfCtx.Err() != nil {
+				// stopped from outside while kanata was starting
+				<-processExited
+				r.cmd = nil
+				r.pid.Store(0)
+				r.retCh <- nil
+				return
+			}
+			if err == errExitedBeforeReady {
+				if cmdErr != nil {
+					err = fmt.Errorf("kanata exited before opening TCP port %d: %v", tcpPort, cmdErr)
+				} else {
+					err = fmt.Errorf("kanata exited before opening TCP port %d", tcpPort)
+				}
+				r.runOnCrashHooks(hookInfo, hooks, cmdErr)
+			} else {
+				cmd.Process.Kill()
+				<-processExited
+			}
+			r.cmd = nil
+			r.pid.Store(0)
+			r.retCh <- err
+			return
+		}
+		logging.Infof(logFields.With("event", "kanata_ready"), "Kanata is ready (TCP port %d is open)", tcpPort)
+		r.eventHooks.Store(&eventHooks{
+			info:           hookInfo,
+			sequential:     hooks.Sequential,
+			onLayerChange:  hooks.OnLayerChange,
+			onConfigReload: hooks.OnConfigReload,
+		})
+		r.readyCh <- struct{}{}
+
+		// Stops kanata (running pre-stop hooks) and waits for it to exit,
+		// before reporting a failed post-start hook.
+		stopAfterHookError := func(hookErr *HookError) {
+			selfCancel(hookErr)
+			<-processExited
+			r.eventHooks.Store(nil)
+			r.cmd = nil
+			r.pid.Store(0)
+			r.retCh <- hookErr
+		}
+
+		err = runAllBlockingHooks(hookInfo, hooks.PostStart, hooks.Sequential, "post-start")
+		if err != nil {
+			stopAfterHookError(&HookError{HookType: "post-start", Err: err})
 			return
 		}
 		anyPostStartAsyncHookErroredCh := make(chan error, 1)
 		allPostStartAsyncHooksExitedCh := make(chan struct{}, 1)
-		err = runAllAsyncHooks(selfCtx, hooks.PostStartAsync, "post-start-async", anyPostStartAsyncHookErroredCh, allPostStartAsyncHooksExitedCh)
+		err = runAllAsyncHooks(selfCtx, hookInfo, hooks.PostStartAsync, "post-start-async", anyPostStartAsyncHookErroredCh, allPostStartAsyncHooksExitedCh)
 		if err != nil {
-			r.retCh <- fmt.Errorf("hook failed: %s", err)
+			stopAfterHookError(&HookError{HookType: "post-start-async", Err: err})
 			return
 		}
 
@@ -115,8 +230,8 @@ func (r *Kanata) RunNonblocking(ctx context.Context, kanataExecutable string, ka
 			case <-selfCtx.Done():
 				return
 			case err := <-anyPostStartAsyncHookErroredCh:
-				log.Errorf("An async hook errored, stopping preset.")
-				selfCancel(err)
+				logging.Errorf(logFields, "An async hook errored, stopping preset.")
+				selfCancel(&HookError{HookType: "post-start-async", Err: err})
 			}
 		}()
 
@@ -131,7 +246,7 @@ func (r *Kanata) RunNonblocking(ctx context.Context, kanataExecutable string, ka
 				case <-r.tcpClient.Reconnect:
 					err := r.tcpClient.Connect(selfCtx, tcpPort)
 					if err != nil {
-						log.Errorf("Failed to connect to kanata via TCP: %v", err)
+						logging.Errorf(logFields, "Failed to connect to kanata via TCP: %v", err)
 					}
 				}
 			}
@@ -140,26 +255,37 @@ func (r *Kanata) RunNonblocking(ctx context.Context, kanataExecutable string, ka
 		// Send request for layer names. We may or may not get response
 		// depending on kanata version). The support for it was implemented in:
 		// https://github.com/jtroo/kanata/commit/d66c3c77bcb3acbf58188272177d64bed4130b6e
-		err = r.SendClientMessage(tcp_client.ClientMessage{RequestLayerNames: struct{}{}})
+		err = r.SendClientMessage(tcp_client.ClientMessage{RequestLayerNames: &struct{}{}})
 		if err != nil {
-			log.Errorf("Failed to send ClientMessage: %v", err)
+			logging.Errorf(logFields, "Failed to send ClientMessage: %v", err)
 			// this is non-critical, so we continue
 		}
 
-		cmdErr := r.cmd.Wait() // block until kanata exits
+		<-processExited // block until kanata exits
+		r.eventHooks.Store(nil)
 		r.cmd = nil
+		r.pid.Store(0)
+		if cmdErr != nil {
+			logging.Infof(logFields.With("event", "kanata_exited", "error", cmdErr), "Kanata exited")
+		} else {
+			logging.Infof(logFields.With("event", "kanata_exited"), "Kanata exited")
+		}
+		if cmdErr != nil && selfCtx.Err() == nil {
+			// kanata crashed or terminated itself with an error
+			r.runOnCrashHooks(hookInfo, hooks, cmdErr)
+		}
 
-		log.Infof("Waiting for all post-start-async hooks to exit")
+		logging.Infof(logFields, "Waiting for all post-start-async hooks to exit")
 		<-allPostStartAsyncHooksExitedCh
-		log.Infof("All post-start-async hooks exited")
+		logging.Infof(logFields, "All post-start-async hooks exited")
 
-		err = runAllBlockingHooks(hooks.PostStop, "post-stop")
+		err = runAllBlockingHooks(hookInfo, hooks.PostStop, hooks.Sequential, "post-stop")
 		if err != nil {
-			r.retCh <- fmt.Errorf("hook failed: %s", err)
+			r.retCh <- &HookError{HookType: "post-stop", Err: err}
 			return
 		}
 
-		selfCtxErr := selfCtx.Err()
+		selfCtxErr := context.Cause(selfCtx)
 		if selfCtxErr != nil && selfCtxErr != context.DeadlineExceeded && selfCtxErr != context.Canceled {
 			// must be an error from async hook
 			r.retCh <- selfCtxErr
@@ -184,10 +310,78 @@ func (r *Kanata) RunNonblocking(ctx context.Context, kanataExecutable string, ka
 	return nil
 }
 
+// Returns TCP port of the current or the last kanata run.
+func (r *Kanata) TcpPort() int {
+	return int(r.tcpPort.Load())
+}
+
+// Returns pid of the running kanata process, or 0 if it's not running.
+func (r *Kanata) Pid() int {
+	return int(r.pid.Load())
+}
+
+var errExitedBeforeReady = errors.New("kanata exited before opening TCP port")
+
+// Polls kanata TCP port with backoff until it accepts a connection.
+// Returns an error if `timeout` passes, kanata exits (`errExitedBeforeReady`)
+// or ctx is cancelled first. A connection accepted after kanata exited
+// doesn't count as ready.
+func waitForTcpPort(ctx context.Context, tcpPort int, timeout time.Duration, processExited <-chan struct{}) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	addr := fmt.Sprintf("localhost:%d", tcpPort)
+	dialer := net.Dialer{Timeout: 500 * time.Millisecond}
+	retryDelay := 50 * time.Millisecond
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			conn.Close()
+			// Something else (e.g. a stale kanata) may be listening on
+			// the port, while our kanata exited after failing to bind it.
+			select {
+			case <-processExited:
+				return errExitedBeforeReady
+			default:
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-processExited:
+			return errExitedBeforeReady
+		case <-deadline.C:
+			return fmt.Errorf("kanata didn't open TCP port %d within %s (last error: %v)", tcpPort, timeout, err)
+		case <-time.After(retryDelay):
+		}
+		retryDelay = min(retryDelay*2, 500*time.Millisecond)
+	}
+}
+
+// Runs on-crash hooks with exit code of kanata available in environment.
+func (r *Kanata) runOnCrashHooks(info hookInfo, hooks config.Hooks, cmdErr error) {
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(cmdErr, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if cmdErr != nil {
+		exitCode = -1
+	}
+	info = info.withEnv(fmt.Sprintf("KANATA_TRAY_EXIT_CODE=%d", exitCode))
+	err := runAllBlockingHooks(info, hooks.OnCrash, hooks.Sequential, "on-crash")
+	if err != nil {
+		logging.Errorf(logging.Fields{"preset": info.presetName}, "on-crash hook failed: %v", err)
+	}
+}
+
 func (r *Kanata) RetCh() <-chan error {
 	return r.retCh
 }
 
+func (r *Kanata) ReadyCh() <-chan struct{} {
+	return r.readyCh
+}
+
 func (r *Kanata) ServerMessageCh() <-chan tcp_client.ServerMessage {
 	return r.tcpClient.ServerMessageCh()
 }
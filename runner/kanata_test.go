@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/rszyma/kanata-tray/config"
+)
+
+func listenLocal(t *testing.T) (net.Listener, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln, ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestWaitForTcpPortReady(t *testing.T) {
+	_, port := listenLocal(t)
+	err := waitForTcpPort(context.Background(), port, time.Second, make(chan struct{}))
+	if err != nil {
+		t.Fatalf("waitForTcpPort = %v, want nil", err)
+	}
+}
+
+func TestWaitForTcpPortProcessExited(t *testing.T) {
+	// Port is held by someone else, but our kanata already exited.
+	_, port := listenLocal(t)
+	processExited := make(chan struct{})
+	close(processExited)
+	err := waitForTcpPort(context.Background(), port, time.Second, processExited)
+	if err != errExitedBeforeReady {
+		t.Fatalf("waitForTcpPort = %v, want errExitedBeforeReady", err)
+	}
+}
+
+func TestWaitForTcpPortTimeout(t *testing.T) {
+	ln, port := listenLocal(t)
+	ln.Close()
+	err := waitForTcpPort(context.Background(), port, 200*time.Millisecond, make(chan struct{}))
+	if err == nil {
+		t.Fatal("waitForTcpPort = nil, want timeout error")
+	}
+}
+
+func TestRunOnCrashHooksExitCode(t *testing.T) {
+	hook, path := envDumpHook(t, "KANATA_TRAY_EXIT_CODE")
+	info := hookInfo{presetName: "test", output: NewHookOutput(presetHookOutputLines)}
+	hooks := config.Hooks{OnCrash: []config.Hook{hook}}
+
+	cmdErr := exec.Command("/bin/sh", "-c", "exit 3").Run()
+	(&Kanata{}).runOnCrashHooks(info, hooks, cmdErr)
+	if got := readFile(t, path); got != "3" {
+		t.Errorf("on-crash hook got KANATA_TRAY_EXIT_CODE=%q, want %q", got, "3")
+	}
+
+	// Kanata was killed or failed to be waited for.
+	(&Kanata{}).runOnCrashHooks(info, hooks, errors.New("wait failed"))
+	if got := readFile(t, path); got != "-1" {
+		t.Errorf("on-crash hook got KANATA_TRAY_EXIT_CODE=%q, want %q", got, "-1")
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"net"
 	"os/exec"
+	"slices"
 	"sort"
 	"sync"
 	"time"
@@ -23,8 +24,11 @@ type ItemAndPresetName[T any] struct {
 
 type Runner struct {
 	retCh                 chan ItemAndPresetName[error]
+	readyCh               chan ItemAndPresetName[struct{}]
 	serverMessageCh       chan ItemAndPresetName[tcp_client.ServerMessage]
 	clientMessageChannels map[string]chan tcp_client.ClientMessage
+	// Maps preset names to output of their hooks. Kept after preset exits.
+	hookOutputs map[string]*HookOutput
 	// Maps preset names to runner indices in `runnerPool` and contexts in `instanceWatcherCtxs`.
 	activeKanataInstances map[string]int
 	// Number of items in channel denotes the number of running kanata instances.
@@ -40,8 +44,10 @@ func NewRunner() *Runner {
 	activeInstancesLimit := 10
 	return &Runner{
 		retCh:                 make(chan ItemAndPresetName[error]),
+		readyCh:               make(chan ItemAndPresetName[struct{}]),
 		serverMessageCh:       make(chan ItemAndPresetName[tcp_client.ServerMessage]),
 		clientMessageChannels: make(map[string]chan tcp_client.ClientMessage),
+		hookOutputs:           make(map[string]*HookOutput),
 		activeKanataInstances: make(map[string]int),
 		kanataInstancePool:    []*Kanata{},
 		instanceWatcherCtxs:   []context.Context{},
@@ -50,11 +56,15 @@ func NewRunner() *Runner {
 }
 
 // Run a new kanata instance from a preset. Blocks until the process is started.
+// Once kanata opens its TCP port, the preset name is sent to `ReadyCh`.
+// If it doesn't happen within `startupTimeout`, kanata is killed and an error is sent to `RetCh`.
+// If `tcpPort` is `config.TcpPortAuto`, a free port is picked (see `TcpPort`).
 // Calling Run when there's a previous preset running with the the same
 // presetName will block until the previous process finishes.
 // To stop running preset, caller needs to cancel ctx.
 func (r *Runner) Run(ctx context.Context, presetName string, kanataExecutable string,
-	kanataConfig string, tcpPort int, hooks config.Hooks, extraArgs []string, kanataLogFile *os.File,
+	kanataConfig string, tcpPort int, hooks config.Hooks, extraArgs []string, kanataLog io.Writer,
+	startupTimeout time.Duration,
 ) error {
 	r.instancesMappingLock.Lock()
 	defer r.instancesMappingLock.Unlock()
@@ -95,8 +105,30 @@ func (r *Runner) Run(ctx context.Context, presetName string, kanataExecutable st
 		instanceIndex = len(r.kanataInstancePool) - 1
 	}
 
+	hookOutput, ok := r.hookOutputs[presetName]
+	if !ok {
+		hookOutput = NewHookOutput(presetHookOutputLines)
+		r.hookOutputs[presetName] = hookOutput
+	}
+
+	if tcpPort == config.TcpPortAuto {
+		// Ports of other running presets are excluded, because kanata
+		// may not have opened them yet.
+		usedPorts := []int{}
+		for name, i := range r.activeKanataInstances {
+			if name != presetName {
+				usedPorts = append(usedPorts, r.kanataInstancePool[i].TcpPort())
+			}
+		}
+		var err error
+		tcpPort, err = pickFreeTcpPort(usedPorts)
+		if err != nil {
+			return fmt.Errorf("failed to pick TCP port: %v", err)
+		}
+	}
+
 	instance := r.kanataInstancePool[instanceIndex]
-	err := instance.RunNonblocking(ctx, kanataExecutable, kanataConfig, tcpPort, hooks, extraArgs, kanataLogFile)
+	err := instance.RunNonblocking(ctx, presetName, kanataExecutable, kanataConfig, tcpPort, hooks, extraArgs, kanataLog, startupTimeout, hookOutput)
 	if err != nil {
 		return fmt.Errorf("failed to run kanata: %v", err)
 	}
@@ -113,6 +145,7 @@ func (r *Runner) Run(ctx context.Context, presetName string, kanataExecutable st
 
 	go func() {
 		retCh := instance.RetCh()
+		readyCh := instance.ReadyCh()
 		serverMessageCh := instance.ServerMessageCh()
 		clientMesasgeCh := r.clientMessageChannels[presetName]
 		for {
@@ -123,7 +156,12 @@ func (r *Runner) Run(ctx context.Context, presetName string, kanataExecutable st
 					PresetName: presetName,
 				}
 				return
+			case <-readyCh:
+				r.readyCh <- ItemAndPresetName[struct{}]{
+					PresetName: presetName,
+				}
 			case msg := <-serverMessageCh:
+				instance.queueEventHooks(msg)
 				r.serverMessageCh <- ItemAndPresetName[tcp_client.ServerMessage]{
 					Item:       msg,
 					PresetName: presetName,
@@ -137,27 +175,67 @@ func (r *Runner) Run(ctx context.Context, presetName string, kanataExecutable st
 	return nil
 }
 
-// An error will be returned if a preset doesn't exists or there's currently no
+// An error will be returned if a preset isn't running or there's currently no
 // opened TCP connection for the given preset.
 //
-// FIXME: message can be sent to a wrong kanata process during live-reloading
-// if a preset has been changed but there's a preset with the same name as in
-// previous kanata-tray configuration. Unlikely to ever happen though
-// (also live-reloading is not implemented at the time of writing).
+// NOTE: when a preset with changed settings is restarted after config reload,
+// messages sent before the old process exits are delivered to the old process.
 func (r *Runner) SendClientMessage(presetName string, msg tcp_client.ClientMessage) error {
 	r.instancesMappingLock.Lock()
 	defer r.instancesMappingLock.Unlock()
 	presetIndex, ok := r.activeKanataInstances[presetName]
 	if !ok {
-		return fmt.Errorf("preset with the given name not found")
+		return fmt.Errorf("preset '%s' is not running", presetName)
 	}
 	return r.kanataInstancePool[presetIndex].SendClientMessage(msg)
 }
 
+// Returns pid of kanata process running for the given preset.
+// Returns false if there's no such preset or kanata process isn't running.
+func (r *Runner) Pid(presetName string) (int, bool) {
+	r.instancesMappingLock.Lock()
+	defer r.instancesMappingLock.Unlock()
+	presetIndex, ok := r.activeKanataInstances[presetName]
+	if !ok {
+		return 0, false
+	}
+	pid := r.kanataInstancePool[presetIndex].Pid()
+	return pid, pid != 0
+}
+
+// Returns TCP port of kanata running for the given preset. If the preset
+// has `tcp_port = "auto"`, it's the port that was picked when it was started.
+// Returns false if there's no such preset running.
+func (r *Runner) TcpPort(presetName string) (int, bool) {
+	r.instancesMappingLock.Lock()
+	defer r.instancesMappingLock.Unlock()
+	presetIndex, ok := r.activeKanataInstances[presetName]
+	if !ok {
+		return 0, false
+	}
+	return r.kanataInstancePool[presetIndex].TcpPort(), true
+}
+
+// Returns recent output of hooks run for the given preset.
+// Returns false if no hooks were run for the preset yet.
+func (r *Runner) HookOutput(presetName string) (*HookOutput, bool) {
+	r.instancesMappingLock.Lock()
+	defer r.instancesMappingLock.Unlock()
+	output, ok := r.hookOutputs[presetName]
+	if !ok || len(output.Lines()) == 0 {
+		return nil, false
+	}
+	return output, true
+}
+
 func (r *Runner) RetCh() <-chan ItemAndPresetName[error] {
 	return r.retCh
 }
 
+func (r *Runner) ReadyCh() <-chan ItemAndPresetName[struct{}] {
+	return r.readyCh
+}
+
 func (r *Runner) ServerMessageCh() <-chan ItemAndPresetName[tcp_client.ServerMessage] {
 	return r.serverMessageCh
 }
@@ -175,3 +253,19 @@ func cmd(ctx context.Context, stdout io.Writer, stderr io.Writer, name string, a
 	}
 	return cmd
 }
+
+// Returns a TCP port, that is currently not used on localhost and is not in `exclude`.
+func pickFreeTcpPort(exclude []int) (int, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+		if !slices.Contains(exclude, port) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port found")
+}
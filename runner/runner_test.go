@@ -0,0 +1,27 @@
+package runner
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestPickFreeTcpPort(t *testing.T) {
+	first, err := pickFreeTcpPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := pickFreeTcpPort([]int{first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port == first {
+		t.Errorf("pickFreeTcpPort returned excluded port %d", port)
+	}
+	// The port must be free to be used by kanata.
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("picked port %d is not free: %v", port, err)
+	}
+	listener.Close()
+}
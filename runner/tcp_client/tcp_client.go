@@ -7,10 +7,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/labstack/gommon/log"
+	"github.com/rszyma/kanata-tray/logging"
 )
 
 type KanataTcpClient struct {
@@ -45,7 +47,7 @@ func (c *KanataTcpClient) Connect(ctx context.Context, port int) error {
 		c.mu.Unlock()
 		return err
 	}
-	log.Infof("Connected to kanata via TCP (%s)", c.conn.LocalAddr().String())
+	logging.Infof(nil, "Connected to kanata via TCP (%s)", c.conn.LocalAddr().String())
 	ctxSend, cancelSenderLoop := context.WithCancel(ctx)
 	go func() {
 		for {
@@ -56,9 +58,9 @@ func (c *KanataTcpClient) Connect(ctx context.Context, port int) error {
 				msgBytes := msg.Bytes()
 				_, err := c.conn.Write(msgBytes)
 				if err != nil {
-					log.Errorf("tcp client: failed to send message: %v", err)
+					logging.Errorf(nil, "tcp client: failed to send message: %v", err)
 				} else {
-					log.Debugf("msg sent: %s", string(msgBytes))
+					logging.Debugf(nil, "msg sent: %s", string(msgBytes))
 				}
 			}
 		}
@@ -71,20 +73,20 @@ func (c *KanataTcpClient) Connect(ctx context.Context, port int) error {
 			var msgBytes = scanner.Bytes()
 			// do not change the following condition (because of cross-version compability)
 			if bytes.Contains(msgBytes, []byte("you sent an invalid message")) {
-				log.Errorf("Kanata disconnected us because we supposedly sent an 'invalid message' (kanata version is too old?)")
+				logging.Errorf(nil, "Kanata disconnected us because we supposedly sent an 'invalid message' (kanata version is too old?)")
 				c.Reconnect <- struct{}{}
 				return
 			}
 			var msg ServerMessage
 			err := json.Unmarshal(msgBytes, &msg)
 			if err != nil {
-				log.Errorf("tcp client: failed to unmarshal message '%s': %v", string(msgBytes), err)
+				logging.Errorf(nil, "tcp client: failed to unmarshal message '%s': %v", string(msgBytes), err)
 				continue
 			}
 			c.serverMessageCh <- msg
 		}
 		if err := scanner.Err(); err != nil {
-			log.Errorf("tcp client: failed to read stream: %v", err)
+			logging.Errorf(nil, "tcp client: failed to read stream: %v", err)
 		}
 	}()
 	return nil
@@ -94,8 +96,94 @@ func (c *KanataTcpClient) ServerMessageCh() <-chan ServerMessage {
 	return c.serverMessageCh
 }
 
+// A message sent to kanata. Exactly one field must be set.
+//
+// Reference: https://github.com/jtroo/kanata/blob/main/tcp_protocol/src/lib.rs
 type ClientMessage struct {
-	RequestLayerNames struct{} `json:"RequestLayerNames"`
+	ChangeLayer             *ChangeLayer  `json:"ChangeLayer,omitempty"`
+	RequestLayerNames       *struct{}     `json:"RequestLayerNames,omitempty"`
+	RequestCurrentLayerInfo *struct{}     `json:"RequestCurrentLayerInfo,omitempty"`
+	RequestCurrentLayerName *struct{}     `json:"RequestCurrentLayerName,omitempty"`
+	ActOnFakeKey            *ActOnFakeKey `json:"ActOnFakeKey,omitempty"`
+	SetMouse                *SetMouse     `json:"SetMouse,omitempty"`
+	Reload                  *struct{}     `json:"Reload,omitempty"`
+	ReloadNext              *struct{}     `json:"ReloadNext,omitempty"`
+	ReloadPrev              *struct{}     `json:"ReloadPrev,omitempty"`
+	ReloadNum               *ReloadNum    `json:"ReloadNum,omitempty"`
+	ReloadFile              *ReloadFile   `json:"ReloadFile,omitempty"`
+}
+
+// {"ChangeLayer":{"new":"layer-name"}}
+type ChangeLayer struct {
+	NewLayer string `json:"new"`
+}
+
+// {"ActOnFakeKey":{"name":"fake-key-name","action":"Tap"}}
+type ActOnFakeKey struct {
+	Name   string        `json:"name"`
+	Action FakeKeyAction `json:"action"`
+}
+
+type FakeKeyAction string
+
+const (
+	FakeKeyPress   FakeKeyAction = "Press"
+	FakeKeyRelease FakeKeyAction = "Release"
+	FakeKeyTap     FakeKeyAction = "Tap"
+	FakeKeyToggle  FakeKeyAction = "Toggle"
+)
+
+// Parses fake key action name, case-insensitive.
+func ParseFakeKeyAction(s string) (FakeKeyAction, error) {
+	for _, action := range []FakeKeyAction{FakeKeyPress, FakeKeyRelease, FakeKeyTap, FakeKeyToggle} {
+		if strings.EqualFold(s, string(action)) {
+			return action, nil
+		}
+	}
+	return "", fmt.Errorf("unknown fake key action '%s' (expected one of: press, release, tap, toggle)", s)
+}
+
+// {"SetMouse":{"x":100,"y":200}}
+type SetMouse struct {
+	X uint16 `json:"x"`
+	Y uint16 `json:"y"`
+}
+
+// {"ReloadNum":{"index":1}}
+type ReloadNum struct {
+	Index uint `json:"index"`
+}
+
+// {"ReloadFile":{"path":"/path/to/config.kbd"}}
+type ReloadFile struct {
+	Path string `json:"path"`
+}
+
+// Returns an error if the message doesn't have exactly one field set.
+func (c *ClientMessage) Validate() error {
+	setCount := 0
+	v := reflect.ValueOf(*c)
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsNil() {
+			setCount += 1
+		}
+	}
+	if setCount != 1 {
+		return fmt.Errorf("expected exactly one message type to be set, found %d", setCount)
+	}
+	if c.ActOnFakeKey != nil {
+		_, err := ParseFakeKeyAction(string(c.ActOnFakeKey.Action))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns true if the message asks kanata for information
+// (e.g. RequestLayerNames), rather than being a command.
+func (c *ClientMessage) IsRequest() bool {
+	return c.RequestLayerNames != nil || c.RequestCurrentLayerInfo != nil || c.RequestCurrentLayerName != nil
 }
 
 func (c *ClientMessage) Bytes() []byte {
@@ -108,10 +196,20 @@ func (c *ClientMessage) Bytes() []byte {
 
 // ==================
 
+// A message received from kanata. At most one field is set.
 type ServerMessage struct {
 	LayerChange      *LayerChange      `json:"LayerChange"`
 	LayerNames       *LayerNames       `json:"LayerNames"`
+	CurrentLayerInfo *CurrentLayerInfo `json:"CurrentLayerInfo"`
+	CurrentLayerName *CurrentLayerName `json:"CurrentLayerName"`
 	ConfigFileReload *ConfigFileReload `json:"ConfigFileReload"`
+	MessagePush      *MessagePush      `json:"MessagePush"`
+	Error            *Error            `json:"Error"`
+
+	// Newer kanata versions respond to every client message with
+	// {"status":"Ok"} or {"status":"Error","msg":"..."}.
+	ResponseStatus string `json:"status"`
+	ResponseMsg    string `json:"msg"`
 }
 
 // {"LayerChange":{"new":"newly-changed-to-layer"}}
@@ -123,6 +221,24 @@ type LayerNames struct {
 	Names []string `json:"names"`
 }
 
+type CurrentLayerInfo struct {
+	Name    string `json:"name"`
+	CfgText string `json:"cfg_text"`
+}
+
+type CurrentLayerName struct {
+	Name string `json:"name"`
+}
+
 type ConfigFileReload struct {
 	New string `json:"new"`
 }
+
+// Sent by kanata when `push-msg` action is triggered.
+type MessagePush struct {
+	Message json.RawMessage `json:"message"`
+}
+
+type Error struct {
+	Msg string `json:"msg"`
+}
@@ -0,0 +1,69 @@
+package tcp_client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClientMessageValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{"change layer", `{"ChangeLayer":{"new":"base"}}`, false},
+		{"request", `{"RequestCurrentLayerName":{}}`, false},
+		{"reload num", `{"ReloadNum":{"index":1}}`, false},
+		{"fake key", `{"ActOnFakeKey":{"name":"vk1","action":"Tap"}}`, false},
+		{"fake key lowercase action", `{"ActOnFakeKey":{"name":"vk1","action":"tap"}}`, false},
+		{"fake key unknown action", `{"ActOnFakeKey":{"name":"vk1","action":"Smash"}}`, true},
+		{"empty", `{}`, true},
+		{"two messages", `{"Reload":{},"ReloadNext":{}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg ClientMessage
+			if err := json.Unmarshal([]byte(tt.json), &msg); err != nil {
+				t.Fatal(err)
+			}
+			err := msg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFakeKeyAction(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    FakeKeyAction
+		wantErr bool
+	}{
+		{"Press", FakeKeyPress, false},
+		{"release", FakeKeyRelease, false},
+		{"TAP", FakeKeyTap, false},
+		{"toggle", FakeKeyToggle, false},
+		{"", "", true},
+		{"tapp", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFakeKeyAction(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFakeKeyAction(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseFakeKeyAction(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestClientMessageIsRequest(t *testing.T) {
+	if !(&ClientMessage{RequestLayerNames: &struct{}{}}).IsRequest() {
+		t.Error("RequestLayerNames should be a request")
+	}
+	if (&ClientMessage{ChangeLayer: &ChangeLayer{NewLayer: "base"}}).IsRequest() {
+		t.Error("ChangeLayer should not be a request")
+	}
+}
@@ -8,7 +8,8 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/labstack/gommon/log"
+	"github.com/rszyma/kanata-tray/icon_render"
+	"github.com/rszyma/kanata-tray/logging"
 )
 
 //go:embed default.ico
@@ -44,10 +45,10 @@ func LoadCustomStatusIcons(configDir string) error {
 		// icon name anyway.
 		match := matches[0]
 
-		log.Infof("loading status icon: %s", match)
-		fileContent, err := os.ReadFile(match)
+		logging.Infof(nil, "loading status icon: %s", match)
+		fileContent, err := icon_render.LoadFile(match)
 		if err != nil {
-			log.Errorf("LoadCustomStatusIcons: os.ReadFile: %v", err)
+			logging.Errorf(nil, "Failed to load status icon, using the built-in one: %v", err)
 			continue
 		}
 
@@ -73,7 +74,7 @@ func CreateDefaultStatusIconsDirIfNotExists(configDir string) error {
 	_, err := os.Stat(customIconsPath)
 
 	if errors.Is(err, fs.ErrNotExist) {
-		log.Infof("status_icons dir doesn't exist. Creating it and populating with the default icons.")
+		logging.Infof(nil, "status_icons dir doesn't exist. Creating it and populating with the default icons.")
 		err := os.MkdirAll(customIconsPath, os.ModePerm)
 		if err != nil {
 			return fmt.Errorf("failed to create folder: %v", err)